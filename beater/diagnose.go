@@ -0,0 +1,209 @@
+package beater
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/affinity226/ftpbeat/keystore"
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+const testUsage = `Usage: ftpbeat test connection [-c file] [-keystore.path file] [-bytes n] [-v]
+
+Connects to the server of the configured input, logs in, checks the remote
+directory and lists the files selected with their sizes, reading the first n
+bytes of the first file with -bytes. Every stage is reported with its time.
+Nothing is published, downloaded to the current directory nor changed on the
+server.
+`
+
+// TestCommand runs the test command line and returns the exit status
+func TestCommand(args []string) int {
+	if err := testCommand(args, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func testCommand(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, testUsage) }
+	configFile := flags.String("c", "ftpbeat.yml", "Configuration file")
+	keystorePath := flags.String("keystore.path", keystore.DefaultPath(), "Keystore file")
+	sample := flags.Int64("bytes", 0, "Bytes of the first file to read")
+	verbose := flags.Bool("v", false, "Log the messages of the beat to stderr")
+	if len(args) < 1 || args[0] != "connection" {
+		flags.Usage()
+		return fmt.Errorf("No test command given")
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *verbose {
+		logp.LogInit(logp.LOG_DEBUG, "", false, true, []string{"*"})
+	}
+
+	if err := keystore.ExportFile(*keystorePath); err != nil {
+		return err
+	}
+	cfg, err := common.LoadFile(*configFile)
+	if err != nil {
+		return fmt.Errorf("Error reading config file: %v", err)
+	}
+	if cfg.HasField("ftpbeat") {
+		cfg, err = cfg.Child("ftpbeat", -1)
+		if err != nil {
+			return fmt.Errorf("Error reading config file: %v", err)
+		}
+	} else {
+		cfg = common.NewConfig()
+	}
+	bt, err := newFtpbeat(nil, cfg)
+	if err != nil {
+		return err
+	}
+
+	if !bt.testConnection(*sample, out) {
+		return fmt.Errorf("Connection test failed")
+	}
+	return nil
+}
+
+// testReport prints the outcome of the stages of a test
+type testReport struct {
+	out    io.Writer
+	failed bool
+}
+
+// stage runs a stage of the test and prints whether it passed, its time and
+// its detail or error
+func (r *testReport) stage(name string, run func() (string, error)) bool {
+	start := time.Now()
+	detail, err := run()
+	elapsed := time.Since(start).Round(time.Millisecond)
+	status := "OK"
+	if err != nil {
+		status = "FAIL"
+		detail = err.Error()
+		r.failed = true
+	}
+	fmt.Fprintf(r.out, "  %-10s %-4s %8v  %s\n", name, status, elapsed, detail)
+	return err == nil
+}
+
+// testConnection connects to the server and goes through the directories
+// the input reads from, reporting each stage
+func (bt *Ftpbeat) testConnection(sample int64, out io.Writer) bool {
+	fmt.Fprintf(out, "%s %s\n", bt.connectType, bt.endpoint())
+	r := &testReport{out: out}
+	bt.testStages(r, sample)
+	if r.failed {
+		fmt.Fprintln(out, "FAIL")
+	} else {
+		fmt.Fprintln(out, "PASS")
+	}
+	return !r.failed
+}
+
+// testStages runs the stages of the test, the runner only being used to read
+func (bt *Ftpbeat) testStages(r *testReport, sample int64) {
+	runner := bt.runner
+	defer runner.Quit()
+
+	if !r.stage("connect", func() (string, error) { return "", runner.Init(bt) }) {
+		return
+	}
+	if !r.stage("login", func() (string, error) { return bt.username, runner.Login(bt) }) {
+		return
+	}
+
+	for _, t := range bt.targets(time.Now()) {
+		bt.remoteDirectory, bt.files = t.dir, t.files
+		if u, ok := runner.(uploader); ok {
+			if !r.stage("directory", func() (string, error) { return t.dir, u.CheckRemoteDirectory(bt) }) {
+				continue
+			}
+		}
+
+		var files []remoteFile
+		listed := r.stage("list", func() (string, error) {
+			var err error
+			if lister, ok := runner.(treeLister); ok && bt.executeType == etMirror {
+				var listing []remoteFile
+				listing, err = lister.ListTree(bt)
+				files = bt.selectTree(listing)
+			} else {
+				files, err = runner.CheckFiles(bt)
+			}
+			return fmt.Sprintf("%d files matching %v", len(files), t.files), err
+		})
+		if !listed {
+			continue
+		}
+		bt.orderFiles(files)
+		for _, file := range files {
+			mtime := "-"
+			if !file.ModTime.IsZero() {
+				mtime = file.ModTime.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(r.out, "    %12d  %19s  %s\n", file.Size, mtime, file.Name)
+		}
+
+		if sample <= 0 || len(files) == 0 {
+			continue
+		}
+		var data []byte
+		read := r.stage("read", func() (string, error) {
+			rc, err := runner.Open(files[0], bt)
+			if err != nil {
+				return "", err
+			}
+			defer rc.Close()
+			data, err = ioutil.ReadAll(io.LimitReader(rc, sample))
+			return fmt.Sprintf("%d bytes of %s", len(data), files[0].Name), err
+		})
+		if read {
+			printSample(r.out, data)
+		}
+	}
+}
+
+// endpoint describes what the input connects to
+func (bt *Ftpbeat) endpoint() string {
+	switch bt.connectType {
+	case ctLocal:
+		return bt.remoteRoot
+	case ctS3:
+		return bt.s3Endpoint.String() + " bucket " + bt.s3.Bucket + "/" + bt.s3.Prefix
+	case ctWebDAV, ctHTTPIndex:
+		return redactURL(bt.httpURL.String())
+	}
+	return net.JoinHostPort(bt.hostname, bt.port)
+}
+
+// printSample prints the lines read, the characters which cannot be printed
+// replaced by dots
+func printSample(out io.Writer, data []byte) {
+	scan := bufio.NewScanner(bytes.NewReader(data))
+	scan.Buffer(nil, len(data)+1)
+	for scan.Scan() {
+		line := strings.Map(func(r rune) rune {
+			if r == '\t' || unicode.IsPrint(r) {
+				return r
+			}
+			return '.'
+		}, scan.Text())
+		fmt.Fprintf(out, "    | %s\n", line)
+	}
+}
@@ -0,0 +1,149 @@
+package beater
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/affinity226/ftpbeat/config"
+)
+
+// testBeat returns the settings testing the connection to the stand-in
+func testBeat(t *testing.T, standIn *ftpStandIn) *Ftpbeat {
+	filter, err := newFileFilter(config.FtpbeatConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt := standIn.beat()
+	bt.connectType = ctFTP
+	bt.username = "ftpbeat"
+	bt.directoryTemplate = "/data"
+	bt.fileTemplates = []string{"*.csv"}
+	bt.filter = filter
+	bt.limiter = &tokenBucket{}
+	bt.runner = &stFTP{}
+	return bt
+}
+
+// stageTimes matches the time column of the stages, which varies
+var stageTimes = regexp.MustCompile(`(?m)^(  \S+ +(?:OK  |FAIL)) +\S+  `)
+
+// testOutput runs the connection test and returns its output with the
+// stage times removed
+func testOutput(bt *Ftpbeat, sample int64) (string, bool) {
+	var out bytes.Buffer
+	passed := bt.testConnection(sample, &out)
+	return stageTimes.ReplaceAllString(out.String(), "$1 -  "), passed
+}
+
+func TestTestConnection(t *testing.T) {
+	standIn := newFTPStandIn(t, "tcp4", "127.0.0.1:0")
+	standIn.files["a.csv"] = "1,2\n\tx\x01y\nrest of the file\n"
+	standIn.listing = []string{
+		"-rw-r--r--    1 ftp      ftp            27 Oct 18  2026 a.csv",
+		"-rw-r--r--    1 ftp      ftp             3 Oct 18  2026 b.txt",
+	}
+	bt := testBeat(t, standIn)
+
+	got, passed := testOutput(bt, 10)
+	want := "ftp " + standIn.ln.Addr().String() + "\n" +
+		"  connect    OK   -  \n" +
+		"  login      OK   -  ftpbeat\n" +
+		"  directory  OK   -  /data\n" +
+		"  list       OK   -  1 files matching [*.csv]\n" +
+		"              27  2026-10-18 00:00:00  a.csv\n" +
+		"  read       OK   -  10 bytes of a.csv\n" +
+		"    | 1,2\n" +
+		"    | \tx.y\n" +
+		"    | r\n" +
+		"PASS\n"
+	if !passed || got != want {
+		t.Errorf("testConnection() = %v\n%s\nwant\n%s", passed, got, want)
+	}
+
+	// Nothing is uploaded, renamed nor deleted
+	for _, command := range []string{"STOR", "RNFR", "DELE"} {
+		if n := standIn.received(command); n != 0 {
+			t.Errorf("%d %s commands", n, command)
+		}
+	}
+}
+
+func TestTestConnectionFailed(t *testing.T) {
+	standIn := newFTPStandIn(t, "tcp4", "127.0.0.1:0")
+	standIn.missingDir = "/data"
+	bt := testBeat(t, standIn)
+	got, passed := testOutput(bt, 10)
+	if passed || !strings.Contains(got, "  directory  FAIL -  550 ") ||
+		strings.Contains(got, "  list ") || !strings.HasSuffix(got, "\nFAIL\n") {
+		t.Errorf("testConnection() = %v on a missing directory\n%s", passed, got)
+	}
+
+	// The stages after a failed connection are not run
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	bt.hostname, bt.port, _ = net.SplitHostPort(ln.Addr().String())
+	bt.runner = &stFTP{}
+	got, passed = testOutput(bt, 10)
+	if passed || !strings.Contains(got, "  connect    FAIL -  ") || strings.Contains(got, "  login ") {
+		t.Errorf("testConnection() = %v on a closed port\n%s", passed, got)
+	}
+}
+
+// A file closed before its end is retrieved over a new connection, the
+// replies to the aborted transfer not being taken for those of the next
+// commands
+func TestFTPRetrClose(t *testing.T) {
+	standIn := newFTPStandIn(t, "tcp4", "127.0.0.1:0")
+	standIn.files["a.csv"] = strings.Repeat("1,2\n", 1000)
+	bt := testBeat(t, standIn)
+
+	f := &stFTP{}
+	if err := f.Init(bt); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Quit()
+	if err := f.Login(bt); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := f.Open(remoteFile{Name: "a.csv"}, bt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(r, make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() after a partial read = %v", err)
+	}
+	if n := standIn.received("USER"); n != 2 {
+		t.Errorf("%d logins after a partial read, want a new connection", n)
+	}
+
+	// Read to its end, the connection is kept
+	r, err = f.Open(remoteFile{Name: "a.csv"}, bt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil || len(b) != 4000 {
+		t.Fatalf("read %d bytes, %v", len(b), err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := standIn.received("USER"); n != 2 {
+		t.Errorf("%d logins after a complete read, want the connection kept", n)
+	}
+	if err := f.KeepAlive(); err != nil {
+		t.Errorf("KeepAlive() = %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package beater
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+var dryRun = flag.Bool("dry-run", false, "List what a run would do with the files and exit, without publishing nor changing anything")
+
+// planned actions on the files
+const (
+	planRead          = "read"
+	planDownload      = "download"
+	planUpload        = "upload"
+	planStat          = "stat"
+	planAdd           = "add"
+	planUpdate        = "update"
+	planSkipUnchanged = "skip-unchanged"
+	planDeleteLocal   = "delete-local"
+	planDefer         = "defer"
+)
+
+// plannedFile is what a run would do with a file
+type plannedFile struct {
+	action string
+	file   remoteFile
+	// detail tells where the file would go or what would follow
+	detail string
+}
+
+// plan goes through the discovery and the selection of files of a run and
+// prints what would be done with each, without transferring, publishing nor
+// changing anything. No registry is kept, the files would be read from their
+// start.
+func (bt *Ftpbeat) plan(out io.Writer) error {
+	bt.sessionMutex.Lock()
+	defer bt.sessionMutex.Unlock()
+
+	err := bt.connectRunner()
+	if err != nil {
+		return err
+	}
+
+	targets := bt.targets(time.Now())
+	bt.filesLeft = bt.maxFilesPerRun
+	for _, t := range targets {
+		bt.remoteDirectory, bt.files = t.dir, t.files
+		plan, err := bt.planPass()
+		if err != nil {
+			if len(targets) == 1 {
+				return err
+			}
+			fmt.Fprintf(out, "%s: skipped: %v\n", t.dir, err)
+			continue
+		}
+
+		fmt.Fprintf(out, "%s: %d files\n", t.dir, len(plan))
+		for _, p := range plan {
+			line := fmt.Sprintf("  %-14s %s  %d bytes", p.action, p.file.Name, p.file.Size)
+			switch p.action {
+			case planRead, planDownload, planAdd, planUpdate:
+				line += ", from offset 0"
+			}
+			if p.detail != "" {
+				line += ", " + p.detail
+			}
+			fmt.Fprintln(out, line)
+		}
+	}
+	return nil
+}
+
+// planPass lists the files of the remote directory as a run would, and tells
+// what would be done with each of those selected
+func (bt *Ftpbeat) planPass() ([]plannedFile, error) {
+	var plan []plannedFile
+	add := func(action string, files []remoteFile, detail func(remoteFile) string) {
+		for _, file := range files {
+			p := plannedFile{action: action, file: file}
+			if detail != nil {
+				p.detail = detail(file)
+			}
+			plan = append(plan, p)
+		}
+	}
+
+	// The files beyond the limit per run are left for the next runs
+	deferred := func(remoteFile) string { return "over max_files_per_run" }
+	queue := func(action string, files []remoteFile, detail func(remoteFile) string) {
+		bt.orderFiles(files)
+		queued := bt.limitFiles(files)
+		add(action, queued, detail)
+		add(planDefer, files[len(queued):], deferred)
+	}
+
+	switch bt.executeType {
+	case etMirror:
+		listing, err := bt.runner.(treeLister).ListTree(bt)
+		if err != nil {
+			return nil, err
+		}
+		files := bt.selectTree(listing)
+		added, updated, unchanged := bt.compareTree(files)
+		isNew := make(map[string]bool)
+		for _, file := range added {
+			isNew[file.Name] = true
+		}
+		changed := append(append([]remoteFile(nil), added...), updated...)
+		bt.orderFiles(changed)
+		queued := bt.limitFiles(changed)
+		for _, file := range queued {
+			action := planUpdate
+			if isNew[file.Name] {
+				action = planAdd
+			}
+			add(action, []remoteFile{file}, func(file remoteFile) string { return "to " + bt.localPath(file.Name) })
+		}
+		add(planDefer, changed[len(queued):], deferred)
+		add(planSkipUnchanged, unchanged, nil)
+		if bt.mayDeleteLocalFiles(files) {
+			add(planDeleteLocal, bt.staleLocalFiles(files), nil)
+		}
+		return plan, nil
+
+	case etPut:
+		if err := bt.runner.(uploader).CheckRemoteDirectory(bt); err != nil {
+			return nil, err
+		}
+		files, err := bt.localFiles()
+		if err != nil {
+			return nil, err
+		}
+		queue(planUpload, files, func(file remoteFile) string {
+			if bt.putAfter == afterDelete {
+				return "then delete it"
+			}
+			return "then move it to " + filepath.Join(bt.putMoveTo, file.Name)
+		})
+		return plan, nil
+	}
+
+	files, err := bt.runner.CheckFiles(bt)
+	if err != nil {
+		return nil, err
+	}
+	switch bt.executeType {
+	case etRead:
+		queue(planRead, files, func(file remoteFile) string {
+			return bt.s3AfterDetail(file)
+		})
+	case etGet:
+		queue(planDownload, files, func(file remoteFile) string {
+			detail := "to " + filepath.Join(bt.currentDirectory, file.Name)
+			if after := bt.s3AfterDetail(file); after != "" {
+				detail += ", " + after
+			}
+			return detail
+		})
+	case etStat:
+		bt.orderFiles(files)
+		add(planStat, files, nil)
+	}
+	return plan, nil
+}
+
+// s3AfterDetail tells what is done with an S3 object once transferred
+func (bt *Ftpbeat) s3AfterDetail(file remoteFile) string {
+	switch bt.s3.After {
+	case afterDelete:
+		return "then delete it"
+	case afterMove:
+		return "then move it to " + bt.s3.MoveTo + file.Name
+	}
+	return ""
+}
@@ -0,0 +1,96 @@
+package beater
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "Rewrite the golden files of the tests")
+
+// TestPlanGolden compares the plans of every execute type over a fixed set
+// of files with testdata/dryrun.golden, covering every planned action
+func TestPlanGolden(t *testing.T) {
+	mtime := time.Date(2026, time.October, 18, 6, 0, 0, 0, time.UTC)
+	write := func(name, contents string) {
+		writeFile(t, name, contents)
+		if err := os.Chtimes(name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scenarios := []struct {
+		executeType string
+		setup       func(bt *Ftpbeat)
+	}{
+		{etRead, nil},
+		{etGet, nil},
+		{etStat, nil},
+		{etPut, func(bt *Ftpbeat) {
+			write(filepath.Join(bt.currentDirectory, "x.csv"), "1,2\n")
+			write(filepath.Join(bt.currentDirectory, "y.csv"), "1,2,3\n")
+			write(filepath.Join(bt.currentDirectory, "z.txt"), "not selected\n")
+		}},
+		{etMirror, func(bt *Ftpbeat) {
+			bt.mirrorDelete = true
+			write(filepath.Join(bt.remoteDirectory, "sub", "d.csv"), "1,2,3,4,5\n")
+			// a.csv is up to date, b.csv changed and e.csv gone from the
+			// remote tree
+			write(filepath.Join(bt.currentDirectory, "a.csv"), "1,2\n")
+			write(filepath.Join(bt.currentDirectory, "b.csv"), "1\n")
+			write(filepath.Join(bt.currentDirectory, "e.csv"), "1\n")
+		}},
+	}
+
+	var out bytes.Buffer
+	for _, scenario := range scenarios {
+		bt := localBeat(t, "*.csv")
+		bt.executeType = scenario.executeType
+		bt.directoryTemplate, bt.fileTemplates = bt.remoteDirectory, bt.files
+		bt.orderBy = orderByName
+		bt.maxFilesPerRun = 2
+		bt.putAfter = afterMove
+		bt.putMoveTo = filepath.Join(t.TempDir(), "sent")
+		bt.runner = &stLocal{}
+		write(filepath.Join(bt.remoteDirectory, "a.csv"), "1,2\n")
+		write(filepath.Join(bt.remoteDirectory, "b.csv"), "1,2,3\n")
+		write(filepath.Join(bt.remoteDirectory, "c.csv"), "1,2,3,4\n")
+		write(filepath.Join(bt.remoteDirectory, "c.txt"), "not selected\n")
+		if scenario.setup != nil {
+			scenario.setup(bt)
+		}
+
+		var plan bytes.Buffer
+		if err := bt.plan(&plan); err != nil {
+			t.Fatalf("%s: %v", scenario.executeType, err)
+		}
+		// The temporary directories change on every run
+		replacer := strings.NewReplacer(bt.remoteDirectory, "REMOTE", bt.currentDirectory, "LOCAL", bt.putMoveTo, "SENT")
+		fmt.Fprintf(&out, "# %s\n%s", scenario.executeType, replacer.Replace(plan.String()))
+	}
+
+	golden := filepath.Join("testdata", "dryrun.golden")
+	if *updateGolden {
+		if err := ioutil.WriteFile(golden, out.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != string(want) {
+		t.Errorf("plan differs from %s, run with -update once checked\n%s", golden, got)
+	}
+
+	for _, action := range []string{planRead, planDownload, planUpload, planStat, planAdd, planUpdate, planSkipUnchanged, planDeleteLocal, planDefer} {
+		if !strings.Contains(out.String(), "  "+action+" ") {
+			t.Errorf("no %s action planned", action)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package beater
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/affinity226/ftpbeat/config"
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// events published about the expected files
+const (
+	fileArrived = "file.arrived"
+	fileLate    = "file.late"
+	fileMissing = "file.missing"
+)
+
+// defaultBusinessDays are the days files are expected on when not configured
+var defaultBusinessDays = []string{"mon", "tue", "wed", "thu", "fri"}
+
+// expectation is a file that must be delivered every business day before a
+// deadline
+type expectation struct {
+	name       string
+	file       string
+	deadline   time.Duration
+	location   *time.Location
+	dateOffset int
+	days       map[time.Weekday]bool
+	holidays   map[string]bool
+
+	// state of the current business day
+	day     string
+	arrived bool
+	missing bool
+}
+
+// parseExpectations parses the expected files from the config
+func parseExpectations(configs []config.ExpectationConfig) ([]*expectation, error) {
+	var expectations []*expectation
+	for index, c := range configs {
+		if c.File == "" {
+			return nil, fmt.Errorf("Expectation #%d has no file", index+1)
+		}
+		e := &expectation{
+			name:       c.Name,
+			file:       c.File,
+			location:   time.Local,
+			dateOffset: c.DateOffset,
+			days:       make(map[time.Weekday]bool),
+			holidays:   make(map[string]bool),
+		}
+		if e.name == "" {
+			e.name = c.File
+		}
+
+		if err := checkTemplate(c.File); err != nil {
+			return nil, fmt.Errorf("Expectation %s: %v", e.name, err)
+		}
+		var err error
+		if e.deadline, err = parseTimeOfDay(c.Deadline); err != nil {
+			return nil, fmt.Errorf("Expectation %s: %v", e.name, err)
+		}
+		if c.Timezone != "" {
+			if e.location, err = time.LoadLocation(c.Timezone); err != nil {
+				return nil, fmt.Errorf("Expectation %s: %v", e.name, err)
+			}
+		}
+
+		days := c.BusinessDays
+		if len(days) == 0 {
+			days = defaultBusinessDays
+		}
+		for _, day := range days {
+			weekday, ok := parseWeekday(day)
+			if !ok {
+				return nil, fmt.Errorf("Expectation %s: invalid business day [%s]", e.name, day)
+			}
+			e.days[weekday] = true
+		}
+		for _, holiday := range c.Holidays {
+			if _, err := time.Parse("2006-01-02", holiday); err != nil {
+				return nil, fmt.Errorf("Expectation %s: invalid holiday [%s], expected YYYY-MM-DD", e.name, holiday)
+			}
+			e.holidays[holiday] = true
+		}
+		expectations = append(expectations, e)
+	}
+	return expectations, nil
+}
+
+// parseWeekday parses a day name, full or abbreviated
+func parseWeekday(s string) (time.Weekday, bool) {
+	s = strings.ToLower(s)
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		name := strings.ToLower(day.String())
+		if s == name || s == name[:3] {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+// businessDay tells whether files are expected on the day
+func (e *expectation) businessDay(day time.Time) bool {
+	return e.days[day.Weekday()] && !e.holidays[day.Format("2006-01-02")]
+}
+
+// fileDate returns the date of the file expected on the day, shifted by the
+// configured number of business days
+func (e *expectation) fileDate(day time.Time) time.Time {
+	step, n := 1, e.dateOffset
+	if n < 0 {
+		step, n = -1, -n
+	}
+	for n > 0 {
+		day = day.AddDate(0, 0, step)
+		if e.businessDay(day) {
+			n--
+		}
+	}
+	return day
+}
+
+// checkExpectations looks for the files expected today in the remote
+// listings, whether or not the files are selected for transfer. The arrival
+// of a file, or its absence once the deadline passed, is published once per
+// day.
+func (bt *Ftpbeat) checkExpectations(files []remoteFile) {
+	now := time.Now()
+	for _, e := range bt.expectations {
+		local := now.In(e.location)
+		day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, e.location)
+		if !e.businessDay(day) {
+			continue
+		}
+		if key := day.Format("2006-01-02"); e.day != key {
+			e.day, e.arrived, e.missing = key, false, false
+		}
+		if e.arrived {
+			continue
+		}
+
+		deadline := time.Date(day.Year(), day.Month(), day.Day(),
+			int(e.deadline/time.Hour), int(e.deadline%time.Hour/time.Minute), 0, 0, e.location)
+		date := e.fileDate(day)
+		pattern := expandDate(e.file, date)
+		fields := common.MapStr{
+			"name":     e.name,
+			"file":     pattern,
+			"date":     date.Format("2006-01-02"),
+			"deadline": common.Time(deadline),
+		}
+
+		// The file arrived when it was last modified, as far as we know
+		if matched := matchTree([]string{pattern}, files, bt.filter.fold); len(matched) > 0 {
+			file := matched[0]
+			arrival := file.ModTime
+			if arrival.IsZero() || arrival.After(now) {
+				arrival = now
+			}
+			delay := arrival.Sub(deadline)
+			event := fileArrived
+			if delay > 0 {
+				event = fileLate
+				logp.Warn("Expected file %s arrived %v late", file.Name, delay)
+			} else {
+				logp.Info("Expected file %s arrived", file.Name)
+			}
+			fields["event"] = event
+			fields["path"] = file.Name
+			fields["arrival"] = common.Time(arrival)
+			fields["delay_s"] = int64(delay.Seconds())
+			bt.publishExpectation(fmt.Sprintf("Expected file %s arrived", file.Name), fields)
+			e.arrived = true
+			continue
+		}
+
+		if now.After(deadline) && !e.missing {
+			logp.Warn("Expected file %s is missing, deadline was %v", pattern, deadline)
+			fields["event"] = fileMissing
+			fields["delay_s"] = int64(now.Sub(deadline).Seconds())
+			bt.publishExpectation(fmt.Sprintf("Expected file %s is missing", pattern), fields)
+			e.missing = true
+		}
+	}
+}
+
+func (bt *Ftpbeat) publishExpectation(message string, fields common.MapStr) {
+	bt.client.PublishEvent(common.MapStr{
+		"@timestamp":  common.Time(time.Now()),
+		"type":        bt.connectType,
+		"message":     message,
+		"expectation": fields,
+	})
+}
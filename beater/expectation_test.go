@@ -0,0 +1,103 @@
+package beater
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/affinity226/ftpbeat/config"
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestFileDate(t *testing.T) {
+	friday := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		offset   int
+		holidays []string
+		want     string
+	}{
+		{0, nil, "2026-10-16"},
+		{-1, nil, "2026-10-15"},
+		{1, nil, "2026-10-19"},
+		{1, []string{"2026-10-19"}, "2026-10-20"},
+		{-2, []string{"2026-10-15"}, "2026-10-13"},
+	}
+	for _, test := range tests {
+		expectations, err := parseExpectations([]config.ExpectationConfig{
+			{File: "a.csv", Deadline: "09:00", DateOffset: test.offset, Holidays: test.holidays},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := expectations[0].fileDate(friday).Format("2006-01-02"); got != test.want {
+			t.Errorf("offset %d, holidays %v: %s, want %s", test.offset, test.holidays, got, test.want)
+		}
+	}
+}
+
+func TestParseExpectationsErrors(t *testing.T) {
+	tests := []struct {
+		config config.ExpectationConfig
+		err    string
+	}{
+		{config.ExpectationConfig{Deadline: "09:00"}, "Expectation #1 has no file"},
+		{config.ExpectationConfig{File: "a.csv", Deadline: "9h"}, "Expectation a.csv:"},
+		{config.ExpectationConfig{File: "a.csv", Deadline: "09:00", Timezone: "Mars/Olympus"}, "Expectation a.csv:"},
+		{config.ExpectationConfig{File: "a.csv", Deadline: "09:00", BusinessDays: []string{"funday"}},
+			"Expectation a.csv: invalid business day [funday]"},
+		{config.ExpectationConfig{File: "a_{YYYYMMDD}.csv", Deadline: "09:00"}, "Invalid placeholder {YYYYMMDD}"},
+	}
+	for _, test := range tests {
+		_, err := parseExpectations([]config.ExpectationConfig{test.config})
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%+v: %v, want %q", test.config, err, test.err)
+		}
+	}
+}
+
+// An expected file is found in the listing even when the filters leave it
+// out of the transfers
+func TestCheckExpectationsListing(t *testing.T) {
+	everyDay := []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"}
+	listing := []remoteFile{
+		{Name: "trades.csv", Size: 10, SizeKnown: true},
+		{Name: "trades.done", Size: 0, SizeKnown: true},
+	}
+	tests := []struct {
+		file    string
+		arrived bool
+	}{
+		{"trades.csv", true},
+		{"trades.done", true},
+		{"positions.csv", false},
+	}
+	for _, test := range tests {
+		expectations, err := parseExpectations([]config.ExpectationConfig{
+			{File: test.file, Deadline: "23:59", BusinessDays: everyDay},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := newFileFilter(config.FtpbeatConfig{MinSize: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &testClient{}
+		bt := &Ftpbeat{files: []string{"trades.*"}, filter: f, expectations: expectations, client: client}
+
+		if selected := strings.Join(fileNames(bt.selectRemote(listing)), " "); selected != "trades.csv" {
+			t.Fatalf("selected %q", selected)
+		}
+		bt.checkExpectations(bt.listed)
+
+		// The file is late or missing if the deadline just passed
+		arrived := false
+		for _, event := range client.events {
+			e := event["expectation"].(common.MapStr)["event"]
+			arrived = arrived || e == fileArrived || e == fileLate
+		}
+		if arrived != test.arrived {
+			t.Errorf("%s: arrived %v, want %v", test.file, arrived, test.arrived)
+		}
+	}
+}
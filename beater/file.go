@@ -0,0 +1,180 @@
+package beater
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// remoteFile is a regular file found in the remote directory. Mode and the
+// owner are only known to some of the runners.
+type remoteFile struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	Mode    os.FileMode
+	Owner   string
+	Group   string
+
+	// SizeKnown is unset when the listing has no size for the file, which
+	// is then let through by the size filter
+	SizeKnown bool
+
+	// published counts the lines of the file published by the attempts to
+	// read it, a retry resuming after them
+	published *int
+}
+
+// fields returns the file metadata added to every event of the file
+func (f remoteFile) fields() common.MapStr {
+	fields := common.MapStr{
+		"name": f.Name,
+		"size": f.Size,
+	}
+	if !f.ModTime.IsZero() {
+		fields["mtime"] = common.Time(f.ModTime)
+	}
+	if f.Mode != 0 {
+		fields["mode"] = fmt.Sprintf("%04o", f.Mode.Perm())
+	}
+	if f.Owner != "" {
+		fields["owner"] = f.Owner
+	}
+	if f.Group != "" {
+		fields["group"] = f.Group
+	}
+	return fields
+}
+
+// newEvent creates the event published for a line of the file
+func newEvent(bt *Ftpbeat, file remoteFile, line string) common.MapStr {
+	return common.MapStr{
+		"@timestamp": common.Time(time.Now()),
+		"type":       bt.connectType,
+		"message":    line,
+		"file":       file.fields(),
+	}
+}
+
+// publishLines publishes an event for every line read from r
+func publishLines(bt *Ftpbeat, file remoteFile, r io.Reader) error {
+	skip := 0
+	if file.published != nil {
+		skip = *file.published
+	}
+	scan := bufio.NewScanner(r)
+	for line := 0; scan.Scan(); line++ {
+		if line < skip {
+			continue
+		}
+		bt.client.PublishEvent(newEvent(bt, file, scan.Text()))
+		if file.published != nil {
+			*file.published++
+		}
+	}
+	return scan.Err()
+}
+
+// genEventForLocalFile publishes the lines of a file copied into the current
+// directory
+func genEventForLocalFile(file remoteFile, bt *Ftpbeat) error {
+	r, err := os.Open(filepath.Join(bt.currentDirectory, file.Name))
+	if err != nil {
+		logp.Err("%v", err)
+		return err
+	}
+	defer r.Close()
+
+	// Some listings have no sizes, the copy tells it
+	if !file.SizeKnown {
+		if info, err := r.Stat(); err == nil {
+			file.Size = info.Size()
+			file.SizeKnown = true
+		}
+	}
+
+	err = publishLines(bt, file, r)
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+	}
+	return err
+}
+
+// matchFiles selects the listed files matching the configured names, in the
+// order of the patterns. A file matching several patterns is only kept once.
+func matchFiles(patterns []string, listing []remoteFile, fold bool) []remoteFile {
+	var matched []remoteFile
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		found := false
+		for _, file := range listing {
+			if !globMatch(pattern, file.Name, fold) {
+				continue
+			}
+			found = true
+			if !seen[file.Name] {
+				seen[file.Name] = true
+				matched = append(matched, file)
+			}
+		}
+		if !found && !strings.ContainsAny(pattern, "*?[") {
+			logp.Warn("File %s not found in the remote directory", pattern)
+		}
+	}
+	return matched
+}
+
+// fileNames returns the names of the files, for logging
+func fileNames(files []remoteFile) []string {
+	names := make([]string, len(files))
+	for i, file := range files {
+		names[i] = file.Name
+	}
+	return names
+}
+
+// downloadSuffix is appended to the name of a file while it is downloaded
+// into the current directory. It is renamed once complete, so a failed
+// download never truncates nor replaces the local copy.
+const downloadSuffix = ".download"
+
+// writeDownload writes r into the temporary file of the download, appending
+// to an earlier partial download when resuming, and returns the bytes written
+func (bt *Ftpbeat) writeDownload(name string, r io.Reader, resume bool) (int64, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if resume {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	outf, err := os.OpenFile(bt.localPath(name)+downloadSuffix, flags, 0666)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(outf, r)
+	if cerr := outf.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// finishDownload renames the complete download over the local file
+func (bt *Ftpbeat) finishDownload(name string) error {
+	local := bt.localPath(name)
+	return os.Rename(local+downloadSuffix, local)
+}
+
+// saveFile downloads r into the current directory. The local file is only
+// replaced once the whole file is read.
+func (bt *Ftpbeat) saveFile(name string, r io.Reader) error {
+	if _, err := bt.writeDownload(name, r, false); err != nil {
+		os.Remove(bt.localPath(name) + downloadSuffix)
+		return err
+	}
+	return bt.finishDownload(name)
+}
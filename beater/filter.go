@@ -0,0 +1,120 @@
+package beater
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/affinity226/ftpbeat/config"
+)
+
+// fileFilter refines the selection of the files by the configured names:
+// regular expressions select more files or exclude some, and files out of
+// the size limits are left out
+type fileFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	fold    bool
+	minSize int64
+	maxSize int64
+}
+
+// newFileFilter compiles the regular expressions of the config
+func newFileFilter(c config.FtpbeatConfig) (*fileFilter, error) {
+	f := &fileFilter{
+		fold:    c.CaseInsensitive,
+		minSize: c.MinSize,
+		maxSize: c.MaxSize,
+	}
+	var err error
+	if f.include, err = f.compile(c.IncludeFiles); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = f.compile(c.ExcludeFiles); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *fileFilter) compile(exprs []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, expr := range exprs {
+		if f.fold {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid file regular expression: %v", err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// matchAny tells whether one of the regular expressions matches the name, or
+// in a tree its base name
+func matchAny(exprs []*regexp.Regexp, name string, tree bool) bool {
+	for _, re := range exprs {
+		if re.MatchString(name) || (tree && re.MatchString(path.Base(name))) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter adds the files included by the regular expressions to those matched
+// by name, and drops the excluded ones and those out of the size limits, the
+// files of unknown size being kept
+func (f *fileFilter) filter(matched, listing []remoteFile, tree bool) []remoteFile {
+	if len(f.include) > 0 {
+		seen := make(map[string]bool)
+		for _, file := range matched {
+			seen[file.Name] = true
+		}
+		for _, file := range listing {
+			if !seen[file.Name] && matchAny(f.include, file.Name, tree) {
+				matched = append(matched, file)
+			}
+		}
+	}
+
+	var selected []remoteFile
+	for _, file := range matched {
+		if matchAny(f.exclude, file.Name, tree) {
+			continue
+		}
+		if file.SizeKnown && (file.Size < f.minSize || (f.maxSize > 0 && file.Size > f.maxSize)) {
+			continue
+		}
+		selected = append(selected, file)
+	}
+	return selected
+}
+
+// selectFiles selects the files of a directory listing to process
+func (bt *Ftpbeat) selectFiles(listing []remoteFile) []remoteFile {
+	return bt.filter.filter(matchFiles(bt.files, listing, bt.filter.fold), listing, false)
+}
+
+// selectRemote selects the files of a remote directory listing to process,
+// the listing being kept for the expectations of the period
+func (bt *Ftpbeat) selectRemote(listing []remoteFile) []remoteFile {
+	bt.listed = append(bt.listed, listing...)
+	return bt.selectFiles(listing)
+}
+
+// selectTree selects the files of a tree listing to process
+func (bt *Ftpbeat) selectTree(listing []remoteFile) []remoteFile {
+	return bt.filter.filter(matchTree(bt.files, listing, bt.filter.fold), listing, true)
+}
+
+// globMatch matches the name against a shell pattern, ignoring the case if
+// fold is set
+func globMatch(pattern, name string, fold bool) bool {
+	if fold {
+		pattern, name = strings.ToLower(pattern), strings.ToLower(name)
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
@@ -0,0 +1,72 @@
+package beater
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/affinity226/ftpbeat/config"
+)
+
+func TestFileFilter(t *testing.T) {
+	listing := []remoteFile{
+		{Name: "trades_20261018.csv", Size: 100, SizeKnown: true},
+		{Name: "TRADES_20261017.CSV", Size: 5, SizeKnown: true},
+		{Name: "trades_20261018.csv.tmp", Size: 100, SizeKnown: true},
+		{Name: "positions.csv", Size: 2000, SizeKnown: true},
+		{Name: ".hidden.csv", Size: 100, SizeKnown: true},
+		{Name: "unknown.csv"},
+	}
+	tests := []struct {
+		name   string
+		config config.FtpbeatConfig
+		want   string
+	}{
+		{"files only", config.FtpbeatConfig{Files: []string{"trades_*"}},
+			"trades_20261018.csv trades_20261018.csv.tmp"},
+		{"case insensitive", config.FtpbeatConfig{Files: []string{"trades_*.csv"}, CaseInsensitive: true},
+			"trades_20261018.csv TRADES_20261017.CSV"},
+		{"include", config.FtpbeatConfig{Files: []string{"positions.csv"}, IncludeFiles: []string{`^trades_\d{8}\.csv$`}},
+			"positions.csv trades_20261018.csv"},
+		{"include case insensitive", config.FtpbeatConfig{IncludeFiles: []string{`^trades_\d{8}\.csv$`}, CaseInsensitive: true},
+			"trades_20261018.csv TRADES_20261017.CSV"},
+		{"exclude", config.FtpbeatConfig{Files: []string{"*"}, ExcludeFiles: []string{`\.tmp$`, `^\.`}},
+			"trades_20261018.csv TRADES_20261017.CSV positions.csv unknown.csv"},
+		{"min size", config.FtpbeatConfig{Files: []string{"*.csv"}, MinSize: 50},
+			"trades_20261018.csv positions.csv .hidden.csv unknown.csv"},
+		{"max size", config.FtpbeatConfig{Files: []string{"*.csv"}, MaxSize: 100},
+			"trades_20261018.csv .hidden.csv unknown.csv"},
+		{"size range", config.FtpbeatConfig{Files: []string{"*"}, MinSize: 10, MaxSize: 1000},
+			"trades_20261018.csv trades_20261018.csv.tmp .hidden.csv unknown.csv"},
+	}
+	for _, test := range tests {
+		f, err := newFileFilter(test.config)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		bt := &Ftpbeat{files: test.config.Files, filter: f}
+		if got := strings.Join(fileNames(bt.selectFiles(listing)), " "); got != test.want {
+			t.Errorf("%s: selected %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	if _, err := newFileFilter(config.FtpbeatConfig{IncludeFiles: []string{"("}}); err == nil {
+		t.Errorf("invalid regular expression accepted")
+	}
+}
+
+func TestSelectTree(t *testing.T) {
+	listing := []remoteFile{
+		{Name: "a.csv", Size: 1, SizeKnown: true},
+		{Name: "2026/10/b.csv", Size: 1, SizeKnown: true},
+		{Name: "2026/10/b.tmp", Size: 1, SizeKnown: true},
+		{Name: "tmp/c.csv", Size: 1, SizeKnown: true},
+	}
+	f, err := newFileFilter(config.FtpbeatConfig{ExcludeFiles: []string{`^tmp/`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt := &Ftpbeat{files: []string{"*.csv"}, filter: f}
+	if got := strings.Join(fileNames(bt.selectTree(listing)), " "); got != "a.csv 2026/10/b.csv" {
+		t.Errorf("selectTree() = %q", got)
+	}
+}
@@ -1,28 +1,34 @@
 package beater
 
 import (
-	"bufio"
+	"bytes"
 	"fmt"
 	"github.com/elastic/beats/libbeat/beat"
-	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/logp"
 	"github.com/jlaffaye/ftp"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
-	"strings"
 	"time"
 )
 
 type stFTP struct {
-	con *ftp.ServerConn
+	con  *ftp.ServerConn
+	ctl  *ftpControl
+	home string
+	// location is the timezone of the dates of the listings
+	location *time.Location
+	// dir is the remote directory the connection is positioned in
+	dir string
 }
 
 func (f *stFTP) Init(bt *Ftpbeat) error {
 	var err error
-	f.con, err = ftp.DialTimeout(fmt.Sprintf("%s:%s", bt.hostname, bt.port), 5*time.Second)
+	f.con, f.ctl, err = dialFTP(bt)
+	f.location = listingLocation(bt)
 	if err != nil {
-		logp.Err(fmt.Sprintf("%v", err))
+		logp.Err("%v", err)
 		return err
 	}
 	return nil
@@ -31,117 +37,295 @@ func (f *stFTP) Init(bt *Ftpbeat) error {
 func (f *stFTP) Login(bt *Ftpbeat) error {
 	err := f.con.Login(bt.username, bt.password)
 	if err != nil {
-		logp.Err(fmt.Sprintf("%v", err))
+		logp.Err("%v", err)
+		return err
+	}
+
+	// Remember the login directory, the session may be reused and the
+	// remote directory can be relative to it
+	f.home, err = f.con.CurrentDir()
+	if err != nil {
+		logp.Err("%v", err)
 	}
 	return err
 
 }
 
-func (f *stFTP) CheckFiles(bt *Ftpbeat) error {
-	var err error
-	err = f.con.ChangeDir(bt.remoteDirectory)
+func (f *stFTP) CheckFiles(bt *Ftpbeat) ([]remoteFile, error) {
+	err := f.changeDir(bt)
 	if err != nil {
-		logp.Err(fmt.Sprintf("%v", err))
-		return err
+		logp.Err("%v", err)
+		return nil, err
 	}
 
-	var temp []string
-	for _, fn := range bt.files {
-		if strings.ContainsAny(fn, "* | ?") {
-			list, err := f.con.NameList(fn)
-			if err == nil {
-				temp = append(temp, list...)
-			} else {
-				logp.Err(fmt.Sprintf("%v", err))
-			}
-		} else {
-			temp = append(temp, fn)
+	entries, err := f.list(".")
+	if err != nil {
+		logp.Err("%v", err)
+		return nil, err
+	}
+
+	var listing []remoteFile
+	for _, entry := range entries {
+		if entry.Type != ftp.EntryTypeFile {
+			continue
 		}
+		listing = append(listing, ftpFile(entry.Name, entry))
 	}
-	bt.files = temp
-	logp.Info("Files : ", bt.files)
-	return nil
+
+	files := bt.selectRemote(listing)
+	logp.Info("Files : %v", fileNames(files))
+	return files, nil
 
 }
 
-func (f *stFTP) GenEventForLocalFile(file string, bt *Ftpbeat, b *beat.Beat) error {
-	var event common.MapStr
-	//r, err := f.con.Retr(file)
-	r, err := os.Open(filepath.Join(bt.currentDirectory, file))
+// ListTree lists the remote directory and its sub directories
+func (f *stFTP) ListTree(bt *Ftpbeat) ([]remoteFile, error) {
+	err := f.changeDir(bt)
 	if err != nil {
-		logp.Err(fmt.Sprintf("%v", err))
-		return err
-	} else {
-		scan := bufio.NewScanner(r)
+		logp.Err("%v", err)
+		return nil, err
+	}
 
-		if err := scan.Err(); err != nil {
-			logp.Err(fmt.Sprintf("%v", err))
-			r.Close()
+	var files []remoteFile
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		entries, err := f.list(dir)
+		if err != nil {
 			return err
 		}
-		for scan.Scan() {
-			event = common.MapStr{
-				"@timestamp": common.Time(time.Now()),
-				"type":       bt.connectType,
+		for _, entry := range entries {
+			name := path.Base(entry.Name)
+			if name == "." || name == ".." {
+				continue
+			}
+			switch entry.Type {
+			case ftp.EntryTypeFolder:
+				if err := walk(path.Join(dir, name)); err != nil {
+					return err
+				}
+			case ftp.EntryTypeFile:
+				files = append(files, ftpFile(path.Join(dir, name), entry))
 			}
-			event["message"] = scan.Text()
-			bt.client.PublishEvent(event)
-			event = nil
 		}
-		r.Close()
+		return nil
 	}
-	return nil
+	if err := walk("."); err != nil {
+		logp.Err("%v", err)
+		return nil, err
+	}
+	return files, nil
+}
 
+// Session opens another control connection, logged in and positioned in the
+// remote directory, since a ServerConn can only run one transfer at a time.
+func (f *stFTP) Session(bt *Ftpbeat) (integratedFunc, error) {
+	s := new(stFTP)
+	if err := s.Init(bt); err != nil {
+		return nil, err
+	}
+	if err := s.Login(bt); err != nil {
+		s.Quit()
+		return nil, err
+	}
+	if err := s.changeDir(bt); err != nil {
+		logp.Err("%v", err)
+		s.Quit()
+		return nil, err
+	}
+	return s, nil
 }
-func (f *stFTP) GenEvent(file string, bt *Ftpbeat, b *beat.Beat) error {
-	var event common.MapStr
-	r, err := f.con.Retr(file)
-	if err != nil {
-		logp.Err(fmt.Sprintf("%v", err))
+
+// Reconnect replaces a broken control connection by a new one positioned in
+// the remote directory
+func (f *stFTP) Reconnect(bt *Ftpbeat) error {
+	f.Quit()
+	if err := f.Init(bt); err != nil {
 		return err
-	} else {
-		scan := bufio.NewScanner(r)
+	}
+	if err := f.Login(bt); err != nil {
+		return err
+	}
+	return f.changeDir(bt)
+}
 
-		if err := scan.Err(); err != nil {
-			logp.Err(fmt.Sprintf("%v", err))
-			r.Close()
-			return err
-		}
-		for scan.Scan() {
-			event = common.MapStr{
-				"@timestamp": common.Time(time.Now()),
-				"type":       bt.connectType,
-			}
-			event["message"] = scan.Text()
-			//b.Events.PublishEvent(event)
-			bt.client.PublishEvent(event)
-			event = nil
-		}
-		r.Close()
+// changeDir positions the connection in the remote directory, relative to
+// the login directory
+func (f *stFTP) changeDir(bt *Ftpbeat) error {
+	f.dir = ""
+	if err := f.con.ChangeDir(f.home); err != nil {
+		return err
+	}
+	if err := f.con.ChangeDir(bt.remoteDirectory); err != nil {
+		return err
 	}
+	f.dir = bt.remoteDirectory
 	return nil
+}
+
+// enter positions the connection in the remote directory unless it already
+// is, the directory changing when it is templated
+func (f *stFTP) enter(bt *Ftpbeat) error {
+	if f.dir == bt.remoteDirectory {
+		return nil
+	}
+	return f.changeDir(bt)
+}
+
+// KeepAlive probes the control connection with a NOOP
+func (f *stFTP) KeepAlive() error {
+	if f.con == nil {
+		return fmt.Errorf("not connected")
+	}
+	return f.con.NoOp()
+}
 
+func (f *stFTP) GenEventForLocalFile(file remoteFile, bt *Ftpbeat, b *beat.Beat) error {
+	return genEventForLocalFile(file, bt)
 }
 
-func (f *stFTP) CopyFiles(file string, bt *Ftpbeat) error {
-	r, err := f.con.Retr(file)
+func (f *stFTP) GenEvent(file remoteFile, bt *Ftpbeat, b *beat.Beat) error {
+	if err := f.enter(bt); err != nil {
+		logp.Err("%v", err)
+		return err
+	}
+	r, err := f.con.Retr(file.Name)
 	if err != nil {
-		logp.Err(fmt.Sprintf("%v : %s", err, file))
+		logp.Err("%v", err)
+		return err
+	}
+	err = publishLines(bt, file, bt.throttle(&idleReader{r, r.SetDeadline, bt.idleReadTimeout}))
+	r.Close()
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+	}
+	return err
+}
+
+func (f *stFTP) CopyFiles(file remoteFile, bt *Ftpbeat) error {
+	if err := f.enter(bt); err != nil {
+		logp.Err("%v", err)
+		return err
+	}
+	r, err := f.con.Retr(file.Name)
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
 		return err
 	} else {
-		outf, err := os.Create(filepath.Join(bt.currentDirectory, file))
+		err = bt.saveFile(file.Name, bt.throttle(&idleReader{r, r.SetDeadline, bt.idleReadTimeout}))
+		r.Close()
 		if err != nil {
-			r.Close()
-			logp.Err(fmt.Sprintf("%v : %s", err, file))
+			logp.Err("%v : %s", err, file.Name)
 			return err
 		}
-		io.Copy(outf, r)
-		outf.Close()
-		r.Close()
 	}
 	return nil
 }
 
+// Open returns the contents of the remote file
+func (f *stFTP) Open(file remoteFile, bt *Ftpbeat) (io.ReadCloser, error) {
+	if err := f.enter(bt); err != nil {
+		return nil, err
+	}
+	resp, err := f.con.Retr(file.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &ftpRetr{Response: resp, f: f, bt: bt}, nil
+}
+
+// ftpRetr is a file being retrieved, which may be closed before its end. The
+// server then replies to the aborted transfer with 426 and maybe 226 on its
+// own schedule, so the connection is dialed again rather than leaving those
+// replies to be taken for those of the next commands.
+type ftpRetr struct {
+	*ftp.Response
+	f   *stFTP
+	bt  *Ftpbeat
+	eof bool
+}
+
+func (r *ftpRetr) Read(b []byte) (int, error) {
+	n, err := r.Response.Read(b)
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+func (r *ftpRetr) Close() error {
+	err := r.Response.Close()
+	if r.eof {
+		return err
+	}
+	return r.f.Reconnect(r.bt)
+}
+
+// CheckRemoteDirectory positions the connection in the remote directory
+func (f *stFTP) CheckRemoteDirectory(bt *Ftpbeat) error {
+	err := f.changeDir(bt)
+	if err != nil {
+		logp.Err("%v", err)
+	}
+	return err
+}
+
+func (f *stFTP) PutFile(file remoteFile, bt *Ftpbeat) error {
+	r, err := os.Open(filepath.Join(bt.currentDirectory, file.Name))
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+		return err
+	}
+	defer r.Close()
+
+	if err := f.enter(bt); err != nil {
+		logp.Err("%v", err)
+		return err
+	}
+	tmp := bt.tempName(file.Name)
+	err = f.con.Stor(tmp, bt.throttle(r))
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+		return err
+	}
+
+	// Some servers do not rename over an existing file
+	if err = f.con.Rename(tmp, file.Name); err != nil {
+		if f.con.Delete(file.Name) == nil {
+			err = f.con.Rename(tmp, file.Name)
+		}
+	}
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+	}
+	return err
+}
+
+// list lists the directory, completing the entries of the library with
+// what it skipped in the lines received
+func (f *stFTP) list(dir string) ([]*ftpEntry, error) {
+	var listing bytes.Buffer
+	f.ctl.listing = &listing
+	entries, err := f.con.List(dir)
+	f.ctl.listing = nil
+	if err != nil {
+		return nil, err
+	}
+	return listEntries(entries, listing.Bytes(), f.location), nil
+}
+
+// ftpFile returns the file with the metadata the listing has
+func ftpFile(name string, entry *ftpEntry) remoteFile {
+	return remoteFile{
+		Name:      name,
+		Size:      int64(entry.Size),
+		SizeKnown: entry.SizeKnown,
+		ModTime:   entry.Time,
+		Mode:      entry.Mode,
+		Owner:     entry.Owner,
+		Group:     entry.Group,
+	}
+}
+
 func (f *stFTP) Quit() {
 	if f.con != nil {
 		f.con.Quit()
@@ -1,15 +1,26 @@
 package beater
 
 import (
+	"crypto/tls"
 	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/affinity226/ftpbeat/config"
 	"github.com/elastic/beats/libbeat/beat"
-	"github.com/elastic/beats/libbeat/cfgfile"
 	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/outputs"
 	"github.com/elastic/beats/libbeat/publisher"
+	"golang.org/x/net/proxy"
 )
 
 // Ftpbeat is a struct to hold the beat config & info
@@ -26,31 +37,127 @@ type Ftpbeat struct {
 	currentDirectory string
 	executeType      string
 	files            []string
+	maxTransfers     int
+	idleTimeout      time.Duration
+	connectTimeout   time.Duration
+	commandTimeout   time.Duration
+	idleReadTimeout  time.Duration
+	transferRetries  int
+	limiter          *tokenBucket
+	ftpMode          string
+	activeAddress    string
+	activePortFrom   int
+	activePortTo     int
+	scpListCommand   string
+	sshHops          []sshHop
+	proxyURL         string
+	dialer           proxy.Dialer
+	s3               config.S3Config
+	s3Endpoint       *url.URL
+	httpURL          *url.URL
+	httpAuth         string
+	httpBearerToken  string
+	tlsConfig        *tls.Config
+	putAfter         string
+	putMoveTo        string
+	putTempSuffix    string
+	putEvent         bool
+	mirrorDelete     bool
+	deleteOnEmpty    bool
+	expectations     []*expectation
+	schedule         *cronSchedule
+	activeWindows    []activeWindow
+	jitter           time.Duration
+	random           *rand.Rand
+	runOnce          bool
+	dryRun           bool
+	failedFiles      int
+	filter           *fileFilter
+	orderBy          string
+	order            string
+	maxFilesPerRun   int
+	filesLeft        int
+	// processed holds the files transferred per remote directory with
+	// max_files_per_run, by name, size and time
+	processed map[string]map[string]bool
+	// listed holds the remote listings of the period, where the expected
+	// files are looked for
+	listed []remoteFile
+
+	// templates of the remote directory and of the files, expanded every
+	// period into the targets
+	directoryTemplate string
+	fileTemplates     []string
+	remoteRoot        string
+	location          *time.Location
+	lookbackDays      int
 	//runner           interface{}
 	runner integratedFunc
 	client publisher.Client
+
+	// sessions kept open between periods
+	sessionMutex sync.Mutex
+	connected    bool
+	sessions     []integratedFunc
+	lastUsed     time.Time
+	idleTimer    *time.Timer
 }
 
 const (
 	// default values
-	defaultPeriod          = "10s"
+	defaultPeriod          = 10 * time.Second
 	defaultHostname        = "127.0.0.1"
-	defaultPort            = "21"
+	defaultFTPPort         = 21
 	defaultConnectType     = "ftp"
 	defaultUsername        = "ftpbeat_user"
 	defaultPassword        = "ftpbeat_pass"
 	defaultRemoteDirectory = "~/"
 	defaultCurrDirectory   = "./"
 	defaultExecuteType     = "get"
+	defaultMaxTransfers    = 1
+	defaultIdleTimeout     = 5 * time.Minute
+	defaultConnectTimeout  = 5 * time.Second
+	defaultCommandTimeout  = 30 * time.Second
+	defaultIdleReadTimeout = time.Minute
+	defaultTransferRetries = 2
+	defaultFTPMode         = "passive"
+	defaultSCPListCommand  = "ls -1p"
+	defaultSSHPort         = 22
+	defaultS3Region        = "us-east-1"
+	defaultHTTPAuth        = "none"
+	defaultPutAfter        = "move"
+	defaultPutMoveTo       = "sent"
+	defaultPutTempSuffix   = ".part"
+	defaultOrder           = "asc"
 
 	// supported Connect types
-	ctFTP  = "ftp"
-	ctSFTP = "sftp"
-
-	etRead = "read"
-	etGet  = "get"
+	ctFTP       = "ftp"
+	ctSFTP      = "sftp"
+	ctSCP       = "scp"
+	ctLocal     = "local"
+	ctS3        = "s3"
+	ctWebDAV    = "webdav"
+	ctHTTPIndex = "http-index"
+
+	etRead   = "read"
+	etGet    = "get"
+	etPut    = "put"
+	etMirror = "mirror"
+	etStat   = "stat"
+
+	// supported FTP data connection modes
+	ftpModePassive = "passive"
+	ftpModeActive  = "active"
 )
 
+// defaultPorts are the ports of the servers by Connect type, the others
+// connect to URLs or do not connect at all
+var defaultPorts = map[string]int{
+	ctFTP:  defaultFTPPort,
+	ctSFTP: defaultSSHPort,
+	ctSCP:  defaultSSHPort,
+}
+
 // New Creates beater
 /*func New() *Ftpbeat {
 	return &Ftpbeat{
@@ -58,10 +165,22 @@ const (
 	}
 }*/
 func New(b *beat.Beat, cfg *common.Config) (beat.Beater, error) {
+	bt, err := newFtpbeat(b, cfg)
+	if err != nil {
+		return nil, err
+	}
+	bt.PrintConfig()
+	return bt, nil
+}
+
+// newFtpbeat sets the beat up from the ftpbeat section of the config
+func newFtpbeat(b *beat.Beat, cfg *common.Config) (*Ftpbeat, error) {
 	bt := &Ftpbeat{
 		done: make(chan struct{}),
 	}
-	err := cfgfile.Read(&bt.beatConfig, "")
+	// Unpacking validates the settings, see config.FtpbeatConfig.Validate
+	bt.beatConfig = new(config.Config)
+	err := cfg.Unpack(&bt.beatConfig.Ftpbeat)
 	if err != nil {
 		return nil, fmt.Errorf("Error reading config file: %v", err)
 	}
@@ -69,68 +188,128 @@ func New(b *beat.Beat, cfg *common.Config) (beat.Beater, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Error setting config file: %v", err)
 	}
-	bt.PrintConfig()
-	return bt, err
+	return bt, nil
 }
 
 type integratedFunc interface {
 	Init(bt *Ftpbeat) error
 	Login(bt *Ftpbeat) error
-	CheckFiles(bt *Ftpbeat) error
-	GenEvent(file string, bt *Ftpbeat, b *beat.Beat) error
-	GenEventForLocalFile(file string, bt *Ftpbeat, b *beat.Beat) error
-	CopyFiles(file string, bt *Ftpbeat) error
+	CheckFiles(bt *Ftpbeat) ([]remoteFile, error)
+	Session(bt *Ftpbeat) (integratedFunc, error)
+	KeepAlive() error
+	Reconnect(bt *Ftpbeat) error
+	GenEvent(file remoteFile, bt *Ftpbeat, b *beat.Beat) error
+	GenEventForLocalFile(file remoteFile, bt *Ftpbeat, b *beat.Beat) error
+	CopyFiles(file remoteFile, bt *Ftpbeat) error
+	Open(file remoteFile, bt *Ftpbeat) (io.ReadCloser, error)
 	Quit()
 }
 
 ///*** Beater interface methods ***///
 
-// Config is a function to read config file
-func (bt *Ftpbeat) Config(b *beat.Beat) error {
-
-	// Load beater beatConfig
-	err := cfgfile.Read(&bt.beatConfig, "")
-	if err != nil {
-		return fmt.Errorf("Error reading config file: %v", err)
-	}
-	bt.PrintConfig()
-
-	return nil
-}
-
 func (bt *Ftpbeat) PrintConfig() {
 	logp.Info("===========================================================")
 	logp.Info("Period           : %v", bt.beatConfig.Ftpbeat.Period)
+	if bt.beatConfig.Ftpbeat.RunOnce || *once {
+		logp.Info("RunOnce          : true")
+	}
+	if *dryRun {
+		logp.Info("DryRun           : true")
+	}
+	if bt.beatConfig.Ftpbeat.Schedule != "" {
+		logp.Info("Schedule         : %v", bt.beatConfig.Ftpbeat.Schedule)
+	}
+	for _, w := range bt.beatConfig.Ftpbeat.ActiveWindows {
+		logp.Info("ActiveWindow     : %s to %s %v %v", w.From, w.To, w.Days, w.Timezone)
+	}
+	if bt.beatConfig.Ftpbeat.Jitter > 0 {
+		logp.Info("Jitter           : %v", bt.beatConfig.Ftpbeat.Jitter)
+	}
 	logp.Info("ConnectType      : %v", bt.beatConfig.Ftpbeat.ConnectType)
 	logp.Info("Hostname         : %v", bt.beatConfig.Ftpbeat.Hostname)
-	logp.Info("Port             : %v", bt.beatConfig.Ftpbeat.Port)
+	if bt.beatConfig.Ftpbeat.Port > 0 {
+		logp.Info("Port             : %v", bt.beatConfig.Ftpbeat.Port)
+	}
 	logp.Info("Username         : %v", bt.beatConfig.Ftpbeat.Username)
+	if bt.beatConfig.Ftpbeat.PasswordFile != "" {
+		logp.Info("Password         : %v from %v", redacted, bt.beatConfig.Ftpbeat.PasswordFile)
+	} else if bt.beatConfig.Ftpbeat.Password != "" {
+		logp.Info("Password         : %v", redacted)
+	}
 	logp.Info("RemoteDirectory  : %v", bt.beatConfig.Ftpbeat.RemoteDirectory)
 	logp.Info("CurrentDirectory : %v", bt.beatConfig.Ftpbeat.CurrentDirectory)
 	logp.Info("Files            : %v", bt.beatConfig.Ftpbeat.Files)
+	if len(bt.beatConfig.Ftpbeat.IncludeFiles) > 0 || len(bt.beatConfig.Ftpbeat.ExcludeFiles) > 0 {
+		logp.Info("IncludeFiles     : %v", bt.beatConfig.Ftpbeat.IncludeFiles)
+		logp.Info("ExcludeFiles     : %v", bt.beatConfig.Ftpbeat.ExcludeFiles)
+	}
+	if bt.beatConfig.Ftpbeat.CaseInsensitive {
+		logp.Info("CaseInsensitive  : true")
+	}
+	if bt.beatConfig.Ftpbeat.MinSize > 0 || bt.beatConfig.Ftpbeat.MaxSize > 0 {
+		logp.Info("FileSize         : min=%v max=%v", bt.beatConfig.Ftpbeat.MinSize, bt.beatConfig.Ftpbeat.MaxSize)
+	}
+	if bt.beatConfig.Ftpbeat.OrderBy != "" {
+		logp.Info("OrderBy          : %v %v", bt.beatConfig.Ftpbeat.OrderBy, bt.beatConfig.Ftpbeat.Order)
+	}
+	if bt.beatConfig.Ftpbeat.MaxFilesPerRun > 0 {
+		logp.Info("MaxFilesPerRun   : %v", bt.beatConfig.Ftpbeat.MaxFilesPerRun)
+	}
 	logp.Info("ExecuteType      : %v", bt.beatConfig.Ftpbeat.ExecuteType)
+	logp.Info("MaxTransfers     : %v", bt.beatConfig.Ftpbeat.MaxConcurrentTransfers)
+	logp.Info("IdleTimeout      : %v", *bt.beatConfig.Ftpbeat.IdleTimeout)
+	logp.Info("Timeouts         : connect=%v command=%v idle_read=%v", bt.beatConfig.Ftpbeat.Timeouts.Connect,
+		bt.beatConfig.Ftpbeat.Timeouts.Command, bt.beatConfig.Ftpbeat.Timeouts.IdleRead)
+	logp.Info("TransferRetries  : %v", *bt.beatConfig.Ftpbeat.TransferRetries)
+	logp.Info("RateLimit        : %v (global %v)", bt.beatConfig.Ftpbeat.RateLimit, bt.beatConfig.Ftpbeat.GlobalRateLimit)
+	for _, w := range bt.beatConfig.Ftpbeat.RateLimitSchedule {
+		logp.Info("RateLimit        : %v from %s to %s", w.RateLimit, w.From, w.To)
+	}
+	if bt.beatConfig.Ftpbeat.ConnectType == ctSCP {
+		logp.Info("SCPListCommand   : %v", bt.beatConfig.Ftpbeat.SCP.ListCommand)
+	}
+	for index, hop := range bt.beatConfig.Ftpbeat.SSH.ProxyJump {
+		logp.Info("ProxyJump #%d     : %s@%s:%d", index+1, hop.Username, hop.Host, hop.Port)
+	}
+	if bt.beatConfig.Ftpbeat.ConnectType == ctFTP {
+		logp.Info("FTPMode          : %v %v %v", bt.beatConfig.Ftpbeat.FTP.Mode,
+			bt.beatConfig.Ftpbeat.FTP.ActiveAddress, bt.beatConfig.Ftpbeat.FTP.ActivePortRange)
+	}
+	if bt.beatConfig.Ftpbeat.ConnectType == ctS3 {
+		logp.Info("S3               : %v bucket=%v prefix=%v region=%v path_style=%v", bt.beatConfig.Ftpbeat.S3.Endpoint,
+			bt.beatConfig.Ftpbeat.S3.Bucket, bt.beatConfig.Ftpbeat.S3.Prefix, bt.beatConfig.Ftpbeat.S3.Region,
+			bt.beatConfig.Ftpbeat.S3.PathStyle)
+	}
+	if bt.beatConfig.Ftpbeat.ConnectType == ctWebDAV || bt.beatConfig.Ftpbeat.ConnectType == ctHTTPIndex {
+		logp.Info("HTTP             : %v auth=%v", redactURL(bt.beatConfig.Ftpbeat.HTTP.URL), bt.beatConfig.Ftpbeat.HTTP.Auth)
+	}
+	if bt.beatConfig.Ftpbeat.ExecuteType == etPut {
+		logp.Info("Put              : after=%v move_to=%v temp_suffix=%v event=%v", bt.beatConfig.Ftpbeat.Put.After,
+			bt.beatConfig.Ftpbeat.Put.MoveTo, bt.beatConfig.Ftpbeat.Put.TempSuffix, bt.beatConfig.Ftpbeat.Put.Event)
+	}
+	if bt.beatConfig.Ftpbeat.ExecuteType == etMirror {
+		logp.Info("Mirror           : delete=%v delete_on_empty_listing=%v", bt.beatConfig.Ftpbeat.Mirror.Delete,
+			bt.beatConfig.Ftpbeat.Mirror.DeleteOnEmptyListing)
+	}
+	for _, e := range bt.beatConfig.Ftpbeat.Expectations {
+		logp.Info("Expectation      : %v by %v %v", e.File, e.Deadline, e.Timezone)
+	}
+	if bt.beatConfig.Ftpbeat.Timezone != "" || bt.beatConfig.Ftpbeat.LookbackDays > 0 {
+		logp.Info("Templates        : timezone=%v lookback_days=%v", bt.beatConfig.Ftpbeat.Timezone,
+			bt.beatConfig.Ftpbeat.LookbackDays)
+	}
+	if bt.beatConfig.Ftpbeat.ProxyURL != "" {
+		logp.Info("ProxyURL         : %v", proxyHost(bt.beatConfig.Ftpbeat.ProxyURL))
+	}
 	logp.Info("===========================================================")
 }
 
 // Setup is a function to setup all beat config & info into the beat struct
 func (bt *Ftpbeat) Setup(b *beat.Beat) error {
 
-	// Config errors handling
-	switch bt.beatConfig.Ftpbeat.ConnectType {
-	case ctFTP, ctSFTP:
-		break
-	default:
-		err := fmt.Errorf("Unknown [%s] Connection type, supported types: `ftp`, `sftp`", bt.beatConfig.Ftpbeat.ConnectType)
-		return err
-	}
-
-	if len(bt.beatConfig.Ftpbeat.Files) < 1 {
-		err := fmt.Errorf("There are no files to get")
-		return err
-	}
-
-	// Setting defaults for missing config
-	if bt.beatConfig.Ftpbeat.Period == "" {
+	// Setting defaults for missing config, the settings were validated when
+	// unpacked
+	if bt.beatConfig.Ftpbeat.Period == 0 {
 		logp.Info("Period not selected, proceeding with '%v' as default", defaultPeriod)
 		bt.beatConfig.Ftpbeat.Period = defaultPeriod
 	}
@@ -145,9 +324,9 @@ func (bt *Ftpbeat) Setup(b *beat.Beat) error {
 		bt.beatConfig.Ftpbeat.Hostname = defaultHostname
 	}
 
-	if bt.beatConfig.Ftpbeat.Port == "" {
-		logp.Info("Port not selected, proceeding with '%v' as default", bt.beatConfig.Ftpbeat.Port)
-		bt.beatConfig.Ftpbeat.Port = defaultPort
+	if port, ok := defaultPorts[bt.beatConfig.Ftpbeat.ConnectType]; ok && bt.beatConfig.Ftpbeat.Port == 0 {
+		logp.Info("Port not selected, proceeding with '%v' as default", port)
+		bt.beatConfig.Ftpbeat.Port = port
 	}
 
 	if bt.beatConfig.Ftpbeat.Username == "" {
@@ -155,7 +334,7 @@ func (bt *Ftpbeat) Setup(b *beat.Beat) error {
 		bt.beatConfig.Ftpbeat.Username = defaultUsername
 	}
 
-	if bt.beatConfig.Ftpbeat.Password == "" {
+	if bt.beatConfig.Ftpbeat.Password == "" && bt.beatConfig.Ftpbeat.PasswordFile == "" {
 		logp.Info("Password not selected, proceeding with default password")
 		bt.beatConfig.Ftpbeat.Password = defaultPassword
 	}
@@ -175,30 +354,187 @@ func (bt *Ftpbeat) Setup(b *beat.Beat) error {
 		bt.beatConfig.Ftpbeat.ExecuteType = defaultExecuteType
 	}
 
-	// Config errors handling
-	switch bt.beatConfig.Ftpbeat.ExecuteType {
-	case etGet, etRead:
-		break
-	default:
-		err := fmt.Errorf("Unknown [%s] Execute type, supported types: `read`, `get`", bt.beatConfig.Ftpbeat.ConnectType)
+	if bt.beatConfig.Ftpbeat.MaxConcurrentTransfers < 1 {
+		logp.Info("Max Concurrent Transfers not selected, proceeding with '%v' as default", defaultMaxTransfers)
+		bt.beatConfig.Ftpbeat.MaxConcurrentTransfers = defaultMaxTransfers
+	}
+
+	// An idle timeout of 0 disconnects after every period, so only a missing
+	// one gets the default
+	if bt.beatConfig.Ftpbeat.IdleTimeout == nil {
+		logp.Info("Idle Timeout not selected, proceeding with '%v' as default", defaultIdleTimeout)
+		idleTimeout := defaultIdleTimeout
+		bt.beatConfig.Ftpbeat.IdleTimeout = &idleTimeout
+	}
+
+	if bt.beatConfig.Ftpbeat.Timeouts.Connect == 0 {
+		logp.Info("Connect Timeout not selected, proceeding with '%v' as default", defaultConnectTimeout)
+		bt.beatConfig.Ftpbeat.Timeouts.Connect = defaultConnectTimeout
+	}
+
+	if bt.beatConfig.Ftpbeat.Timeouts.Command == 0 {
+		logp.Info("Command Timeout not selected, proceeding with '%v' as default", defaultCommandTimeout)
+		bt.beatConfig.Ftpbeat.Timeouts.Command = defaultCommandTimeout
+	}
+
+	if bt.beatConfig.Ftpbeat.Timeouts.IdleRead == 0 {
+		logp.Info("Idle Read Timeout not selected, proceeding with '%v' as default", defaultIdleReadTimeout)
+		bt.beatConfig.Ftpbeat.Timeouts.IdleRead = defaultIdleReadTimeout
+	}
+
+	if bt.beatConfig.Ftpbeat.TransferRetries == nil {
+		logp.Info("Transfer Retries not selected, proceeding with '%v' as default", defaultTransferRetries)
+		retries := defaultTransferRetries
+		bt.beatConfig.Ftpbeat.TransferRetries = &retries
+	}
+
+	if bt.beatConfig.Ftpbeat.SCP.ListCommand == "" {
+		bt.beatConfig.Ftpbeat.SCP.ListCommand = defaultSCPListCommand
+	}
+
+	if bt.beatConfig.Ftpbeat.FTP.Mode == "" {
+		bt.beatConfig.Ftpbeat.FTP.Mode = defaultFTPMode
+	}
+
+	bt.period = bt.beatConfig.Ftpbeat.Period
+	bt.jitter = bt.beatConfig.Ftpbeat.Jitter
+	bt.idleTimeout = *bt.beatConfig.Ftpbeat.IdleTimeout
+	bt.connectTimeout = bt.beatConfig.Ftpbeat.Timeouts.Connect
+	bt.commandTimeout = bt.beatConfig.Ftpbeat.Timeouts.Command
+	bt.idleReadTimeout = bt.beatConfig.Ftpbeat.Timeouts.IdleRead
+
+	// Parse the rate limits, the schedule is evaluated on every read
+	windows, err := parseRateWindows(bt.beatConfig.Ftpbeat.RateLimitSchedule)
+	if err != nil {
+		return err
+	}
+	rateLimit := bt.beatConfig.Ftpbeat.RateLimit
+	bt.limiter = &tokenBucket{rate: func() int64 {
+		return currentRateLimit(windows, rateLimit)
+	}}
+	globalRateLimit := bt.beatConfig.Ftpbeat.GlobalRateLimit
+	globalLimiter.rate = func() int64 {
+		return globalRateLimit
+	}
+
+	// Parse the active mode port range
+	bt.activePortFrom, bt.activePortTo, err = parsePortRange(bt.beatConfig.Ftpbeat.FTP.ActivePortRange)
+	if err != nil {
+		return err
+	}
+
+	// The server is reached through the proxy, which cannot take the
+	// connections of the server back in active mode
+	bt.dialer, err = newDialer(bt.beatConfig.Ftpbeat.ProxyURL, bt.connectTimeout)
+	if err != nil {
+		return err
+	}
+
+	// Object storage settings, the credentials come from the environment
+	// when not configured
+	if bt.beatConfig.Ftpbeat.ConnectType == ctS3 {
+		if err := bt.setupS3(); err != nil {
+			return err
+		}
+	}
+
+	// Uploads, the local files are moved or deleted once uploaded
+	if bt.beatConfig.Ftpbeat.ExecuteType == etPut {
+		if err := bt.setupPut(); err != nil {
+			return err
+		}
+	}
+
+	// Directory published over HTTP
+	if bt.beatConfig.Ftpbeat.ConnectType == ctWebDAV || bt.beatConfig.Ftpbeat.ConnectType == ctHTTPIndex {
+		if err := bt.setupHTTP(); err != nil {
+			return err
+		}
+	}
+
+	// The cron schedule replaces the period, the server being polled only
+	// within the active windows
+	if bt.beatConfig.Ftpbeat.Schedule != "" {
+		bt.schedule, err = parseCron(bt.beatConfig.Ftpbeat.Schedule)
+		if err != nil {
+			return err
+		}
+		if bt.schedule.next(time.Now()).IsZero() {
+			return fmt.Errorf("Schedule [%s] never runs", bt.beatConfig.Ftpbeat.Schedule)
+		}
+	}
+	bt.activeWindows, err = parseActiveWindows(bt.beatConfig.Ftpbeat.ActiveWindows)
+	if err != nil {
+		return err
+	}
+	bt.random = newRandom()
+
+	// Placeholders are expanded in the timezone, the local one by default
+	bt.location = time.Local
+	if bt.beatConfig.Ftpbeat.Timezone != "" {
+		bt.location, err = time.LoadLocation(bt.beatConfig.Ftpbeat.Timezone)
+		if err != nil {
+			return err
+		}
+	}
+	if bt.beatConfig.Ftpbeat.Mirror.Delete && hasPlaceholders(bt.beatConfig.Ftpbeat.RemoteDirectory) {
+		return fmt.Errorf("Mirror delete cannot be used with a templated remote directory")
+	}
+
+	// Selection of the files beyond their names
+	bt.filter, err = newFileFilter(bt.beatConfig.Ftpbeat)
+	if err != nil {
 		return err
 	}
 
-	// Parse the Period string
-	var durationParseError error
-	bt.period, durationParseError = time.ParseDuration(bt.beatConfig.Ftpbeat.Period)
-	if durationParseError != nil {
-		return durationParseError
+	// Order of the files, a backlog is drained over several runs
+	if bt.beatConfig.Ftpbeat.Order == "" {
+		bt.beatConfig.Ftpbeat.Order = defaultOrder
 	}
+	bt.orderBy = bt.beatConfig.Ftpbeat.OrderBy
+	bt.order = bt.beatConfig.Ftpbeat.Order
+	bt.maxFilesPerRun = bt.beatConfig.Ftpbeat.MaxFilesPerRun
 
-	// Handle password decryption and save in the bt
-	if bt.beatConfig.Ftpbeat.Password != "" {
+	// Files expected every business day
+	bt.expectations, err = parseExpectations(bt.beatConfig.Ftpbeat.Expectations)
+	if err != nil {
+		return err
+	}
+
+	// Build the chain of SSH hops, the jump hosts first
+	for index, hop := range bt.beatConfig.Ftpbeat.SSH.ProxyJump {
+		if hop.Port == 0 {
+			bt.beatConfig.Ftpbeat.SSH.ProxyJump[index].Port = defaultSSHPort
+			hop.Port = defaultSSHPort
+		}
+		if hop.PasswordFile != "" {
+			if hop.Password, err = readSecretFile(hop.PasswordFile); err != nil {
+				return err
+			}
+		}
+		bt.sshHops = append(bt.sshHops, sshHop{
+			addr:       net.JoinHostPort(hop.Host, strconv.Itoa(hop.Port)),
+			username:   hop.Username,
+			password:   hop.Password,
+			keyFile:    hop.KeyFile,
+			knownHosts: hop.KnownHosts,
+			hostKey:    hop.HostKey,
+		})
+	}
+
+	// The password may be kept in a file of its own, environment variables
+	// and keystore secrets are expanded when the config is read
+	if bt.beatConfig.Ftpbeat.PasswordFile != "" {
+		bt.beatConfig.Ftpbeat.Password, err = readSecretFile(bt.beatConfig.Ftpbeat.PasswordFile)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Save config values to the bt
 	bt.connectType = bt.beatConfig.Ftpbeat.ConnectType
 	bt.hostname = bt.beatConfig.Ftpbeat.Hostname
-	bt.port = bt.beatConfig.Ftpbeat.Port
+	bt.port = strconv.Itoa(bt.beatConfig.Ftpbeat.Port)
 	bt.username = bt.beatConfig.Ftpbeat.Username
 	bt.password = bt.beatConfig.Ftpbeat.Password
 
@@ -206,6 +542,39 @@ func (bt *Ftpbeat) Setup(b *beat.Beat) error {
 	bt.remoteDirectory = bt.beatConfig.Ftpbeat.RemoteDirectory
 	bt.currentDirectory = bt.beatConfig.Ftpbeat.CurrentDirectory
 	bt.executeType = bt.beatConfig.Ftpbeat.ExecuteType
+	bt.maxTransfers = bt.beatConfig.Ftpbeat.MaxConcurrentTransfers
+	bt.transferRetries = *bt.beatConfig.Ftpbeat.TransferRetries
+	bt.ftpMode = bt.beatConfig.Ftpbeat.FTP.Mode
+	bt.activeAddress = bt.beatConfig.Ftpbeat.FTP.ActiveAddress
+	bt.scpListCommand = bt.beatConfig.Ftpbeat.SCP.ListCommand
+	bt.proxyURL = bt.beatConfig.Ftpbeat.ProxyURL
+	bt.mirrorDelete = bt.beatConfig.Ftpbeat.Mirror.Delete
+	bt.deleteOnEmpty = bt.beatConfig.Ftpbeat.Mirror.DeleteOnEmptyListing
+	bt.runOnce = bt.beatConfig.Ftpbeat.RunOnce || *once
+	bt.dryRun = *dryRun
+	bt.directoryTemplate = bt.remoteDirectory
+	bt.fileTemplates = bt.files
+	bt.remoteRoot = templateRoot(bt.remoteDirectory)
+	bt.lookbackDays = bt.beatConfig.Ftpbeat.LookbackDays
+	bt.sshHops = append(bt.sshHops, sshHop{
+		addr:       net.JoinHostPort(bt.hostname, bt.port),
+		username:   bt.username,
+		password:   bt.password,
+		keyFile:    bt.beatConfig.Ftpbeat.SSH.KeyFile,
+		knownHosts: bt.beatConfig.Ftpbeat.SSH.KnownHosts,
+		hostKey:    bt.beatConfig.Ftpbeat.SSH.HostKey,
+	})
+
+	for _, template := range append([]string{bt.directoryTemplate}, bt.fileTemplates...) {
+		if err := checkTemplate(template); err != nil {
+			return err
+		}
+	}
+	if bt.mirrorDelete {
+		if err := checkMirrorDirectory(bt.currentDirectory); err != nil {
+			return err
+		}
+	}
 
 	logp.Info("Total # of files to get : %d", len(bt.files))
 	for index, file := range bt.files {
@@ -218,24 +587,179 @@ func (bt *Ftpbeat) Setup(b *beat.Beat) error {
 		break
 	case ctSFTP:
 		bt.runner = new(stSFTP)
+		warnUnverifiedHosts(bt.sshHops)
 		break
+	case ctSCP:
+		bt.runner = new(stSCP)
+		warnUnverifiedHosts(bt.sshHops)
+		break
+	case ctLocal:
+		bt.runner = new(stLocal)
+		break
+	case ctS3:
+		bt.runner = new(stS3)
+		break
+	case ctWebDAV, ctHTTPIndex:
+		bt.runner = new(stHTTP)
+		break
+	}
+
+	if _, ok := bt.runner.(uploader); bt.executeType == etPut && !ok {
+		return fmt.Errorf("Execute type `put` is not supported by the [%s] Connection type", bt.connectType)
+	}
+	if _, ok := bt.runner.(treeLister); bt.executeType == etMirror && !ok {
+		return fmt.Errorf("Execute type `mirror` is not supported by the [%s] Connection type", bt.connectType)
 	}
 
 	return nil
 }
 
+// setupS3 applies the defaults of the object storage settings and checks them
+func (bt *Ftpbeat) setupS3() error {
+	s3 := &bt.beatConfig.Ftpbeat.S3
+	if s3.Region == "" {
+		logp.Info("S3 Region not selected, proceeding with '%v' as default", defaultS3Region)
+		s3.Region = defaultS3Region
+	}
+	if s3.Endpoint == "" {
+		s3.Endpoint = "https://s3." + s3.Region + ".amazonaws.com"
+		logp.Info("S3 Endpoint not selected, proceeding with '%v' as default", s3.Endpoint)
+	}
+	if s3.Prefix != "" && !strings.HasSuffix(s3.Prefix, "/") {
+		s3.Prefix += "/"
+	}
+	if s3.MoveTo != "" && !strings.HasSuffix(s3.MoveTo, "/") {
+		s3.MoveTo += "/"
+	}
+	if s3.AccessKeyID == "" {
+		s3.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		s3.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		s3.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if s3.AccessKeyID == "" {
+		logp.Info("S3 credentials not found, proceeding with anonymous requests")
+	}
+
+	endpoint, err := url.Parse(s3.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return fmt.Errorf("Invalid S3 endpoint [%s], expected http(s)://host[:port]", s3.Endpoint)
+	}
+
+	bt.s3 = *s3
+	bt.s3Endpoint = endpoint
+	return nil
+}
+
+// setupPut applies the defaults of the upload settings and checks them
+func (bt *Ftpbeat) setupPut() error {
+	put := &bt.beatConfig.Ftpbeat.Put
+	if put.After == "" {
+		logp.Info("Put After not selected, proceeding with '%v' as default", defaultPutAfter)
+		put.After = defaultPutAfter
+	}
+	if put.After == afterMove && put.MoveTo == "" {
+		logp.Info("Put Move To not selected, proceeding with '%v' as default", defaultPutMoveTo)
+		put.MoveTo = defaultPutMoveTo
+	}
+	if put.TempSuffix == "" {
+		put.TempSuffix = defaultPutTempSuffix
+	}
+
+	bt.putAfter = put.After
+	bt.putMoveTo = put.MoveTo
+	if !filepath.IsAbs(bt.putMoveTo) {
+		bt.putMoveTo = filepath.Join(bt.beatConfig.Ftpbeat.CurrentDirectory, bt.putMoveTo)
+	}
+	bt.putTempSuffix = put.TempSuffix
+	bt.putEvent = put.Event
+	return nil
+}
+
+// setupHTTP applies the defaults of the HTTP settings and checks them
+func (bt *Ftpbeat) setupHTTP() error {
+	httpConfig := &bt.beatConfig.Ftpbeat.HTTP
+	u, err := parseHTTPURL(httpConfig.URL)
+	if err != nil {
+		return err
+	}
+
+	if httpConfig.Auth == "" {
+		logp.Info("HTTP Auth not selected, proceeding with '%v' as default", defaultHTTPAuth)
+		httpConfig.Auth = defaultHTTPAuth
+	}
+
+	tlsConfig, err := outputs.LoadTLSConfig(httpConfig.TLS)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		bt.tlsConfig = tlsConfig.BuildModuleConfig(u.Hostname())
+	}
+
+	bt.httpURL = u
+	bt.httpAuth = httpConfig.Auth
+	bt.httpBearerToken = httpConfig.BearerToken
+	return nil
+}
+
+// parsePortRange parses a "from-to" port range, a single port or nothing
+func parsePortRange(s string) (int, int, error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+
+	bounds := strings.SplitN(s, "-", 2)
+	from, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid port range [%s]", s)
+	}
+	to := from
+	if len(bounds) == 2 {
+		to, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+		if err != nil {
+			return 0, 0, fmt.Errorf("Invalid port range [%s]", s)
+		}
+	}
+	if from < 1 || to > 65535 || from > to {
+		return 0, 0, fmt.Errorf("Invalid port range [%s]", s)
+	}
+	return from, to, nil
+}
+
 // Run is a functions that runs the beat
 func (bt *Ftpbeat) Run(b *beat.Beat) error {
 	logp.Info("ftpbeat is running! Hit CTRL-C to stop it.")
 
 	bt.client = b.Publisher.Connect()
+	defer bt.closeSessions()
+
+	// A dry run only tells what a run would do
+	if bt.dryRun {
+		return bt.plan(os.Stdout)
+	}
+	if bt.runOnce {
+		return bt.singlePass(b)
+	}
 
-	ticker := time.NewTicker(bt.period)
+	scheduled := time.Now()
 	for {
+		scheduled = bt.nextRun(scheduled, time.Now())
+		if scheduled.IsZero() {
+			logp.Warn("The schedule has no next run, stopping")
+			<-bt.done
+			return nil
+		}
+		timer := time.NewTimer(time.Until(scheduled) + bt.jitterDelay())
 		select {
 		case <-bt.done:
+			timer.Stop()
 			return nil
-		case <-ticker.C:
+		case <-timer.C:
+		}
+
+		if !bt.active(scheduled) {
+			logp.Info("Outside of the active windows, skipping the run of %v", scheduled)
+			continue
 		}
 
 		err := bt.beat(b)
@@ -260,29 +784,73 @@ func (bt *Ftpbeat) Stop() {
 func (bt *Ftpbeat) beat(b *beat.Beat) error {
 	logp.Info("Run Beat Periodically")
 
-	err := bt.runner.Init(bt)
+	bt.sessionMutex.Lock()
+	defer bt.sessionMutex.Unlock()
+
+	err := bt.connectRunner()
 	if err != nil {
 		return err
 	}
-	defer bt.runner.Quit()
+	defer bt.releaseSessions()
+
+	// A templated directory may not exist for every day of the lookback
+	// window, only a single directory failing fails the period
+	targets := bt.targets(time.Now())
+	bt.filesLeft = bt.maxFilesPerRun
+	bt.listed = nil
+	for _, t := range targets {
+		bt.remoteDirectory, bt.files = t.dir, t.files
+		if err := bt.pass(b); err != nil {
+			if len(targets) == 1 {
+				return err
+			}
+			logp.Warn("Skipping %s: %v", t.dir, err)
+			continue
+		}
+	}
+	bt.checkExpectations(bt.listed)
 
-	err = bt.runner.Login(bt)
-	if err != nil {
-		return err
+	// Great success!
+	return nil
+}
+
+// pass processes the files of the remote directory
+func (bt *Ftpbeat) pass(b *beat.Beat) error {
+	switch bt.executeType {
+	case etMirror:
+		return bt.mirror(b)
+	case etStat:
+		return bt.stat()
 	}
 
-	err = bt.runner.CheckFiles(bt)
+	// Files are uploaded from the current directory in put mode
+	var files []remoteFile
+	var err error
+	if bt.executeType == etPut {
+		err = bt.runner.(uploader).CheckRemoteDirectory(bt)
+		if err == nil {
+			files, err = bt.localFiles()
+		}
+	} else {
+		files, err = bt.runner.CheckFiles(bt)
+	}
 	if err != nil {
 		return err
 	}
-	for _, file := range bt.files {
-		if bt.executeType == etRead {
-			bt.runner.GenEvent(file, bt, b)
-		} else {
-			bt.runner.CopyFiles(file, bt)
-			bt.runner.GenEventForLocalFile(file, bt, b)
-		}
+
+	// Put moves or deletes the files it uploaded, they are not tracked
+	pending := files
+	if bt.executeType != etPut {
+		pending = bt.pendingFiles(files)
+	}
+	bt.orderFiles(pending)
+	queued := bt.limitFiles(pending)
+	failed := bt.transfer(queued, b)
+	if bt.executeType != etPut {
+		bt.markProcessed(queued, failed)
+	}
+	if len(failed) > 0 {
+		logp.Warn("%d of %d files failed: %v", len(failed), len(queued), failed)
 	}
-	// Great success!
 	return nil
 }
@@ -0,0 +1,280 @@
+package beater
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+)
+
+// ftpControl is the control connection handed to the ftp library. The
+// library dials its data connections through dialData, which in active mode
+// listens, announces the listener to the server with PORT or EPRT and hands
+// back the connection the server opens. The library having already sent PASV
+// or EPSV then, the server must accept them: PORT replaces the passive port.
+//
+// The data connections of a listing are recorded, the library dropping the
+// permissions and the owner of the files and the formats it does not know.
+type ftpControl struct {
+	*deadlineConn
+	bt *Ftpbeat
+
+	// pending holds what was read past a reply read here, which the library
+	// reads first
+	pending bytes.Buffer
+	// listing records the data of the connections while set
+	listing *bytes.Buffer
+}
+
+// dialFTP connects the control connection and hands it to the library, the
+// data connections going through the same dialer or listener
+func dialFTP(bt *Ftpbeat) (*ftp.ServerConn, *ftpControl, error) {
+	addr := net.JoinHostPort(bt.hostname, bt.port)
+	conn, err := bt.dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The control connection only reads while waiting for a reply, so the
+	// command timeout can always be enforced on it
+	c := &ftpControl{deadlineConn: newDeadlineConn(conn, bt.commandTimeout, true), bt: bt}
+	control := net.Conn(c)
+	con, err := ftp.Dial(addr,
+		ftp.DialWithDialFunc(func(network, address string) (net.Conn, error) {
+			// The first connection dialed by the library is the control one
+			if control != nil {
+				conn := control
+				control = nil
+				return conn, nil
+			}
+			return c.dialData(network, address)
+		}),
+		ftp.DialWithLocation(listingLocation(bt)),
+	)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return con, c, nil
+}
+
+// listingLocation is the timezone the dates of the listings are read in, the
+// one of the placeholders as both are the timezone of the server
+func listingLocation(bt *Ftpbeat) *time.Location {
+	if bt.location == nil {
+		return time.UTC
+	}
+	return bt.location
+}
+
+// RemoteAddr is the address of the server, or of the proxy, the library
+// requiring a TCP address
+func (c *ftpControl) RemoteAddr() net.Addr {
+	if addr, ok := c.deadlineConn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr
+	}
+	return &net.TCPAddr{}
+}
+
+func (c *ftpControl) Read(b []byte) (int, error) {
+	if c.pending.Len() > 0 {
+		return c.pending.Read(b)
+	}
+	return c.deadlineConn.Read(b)
+}
+
+// dialData opens a data connection. Passive ones go to the server of the
+// control connection whatever the address of the PASV reply, which may be a
+// private one, and through the proxy if any.
+func (c *ftpControl) dialData(network, address string) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if c.bt.ftpMode == ftpModeActive {
+		conn, err = c.port()
+	} else {
+		var port string
+		_, port, err = net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		conn, err = c.bt.dialer.Dial(network, net.JoinHostPort(c.bt.hostname, port))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.listing != nil {
+		conn = &recordConn{conn, c.listing}
+	}
+	return conn, nil
+}
+
+// port listens for the next data connection and announces the listener to
+// the server with PORT, or EPRT for IPv6. The library waits for the data
+// connection, so nothing else is sent on the control connection meanwhile.
+func (c *ftpControl) port() (net.Conn, error) {
+	ln, err := c.listen()
+	if err != nil {
+		return nil, err
+	}
+
+	addr := ln.Addr().(*net.TCPAddr)
+	var cmd string
+	if ip := addr.IP.To4(); ip != nil {
+		cmd = fmt.Sprintf("PORT %d,%d,%d,%d,%d,%d", ip[0], ip[1], ip[2], ip[3], addr.Port/256, addr.Port%256)
+	} else {
+		cmd = fmt.Sprintf("EPRT |2|%s|%d|", addr.IP.String(), addr.Port)
+	}
+	if _, err := c.deadlineConn.Write([]byte(cmd + "\r\n")); err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	r := bufio.NewReader(c)
+	_, _, err = textproto.NewReader(r).ReadResponse(ftp.StatusCommandOK)
+	if n := r.Buffered(); n > 0 {
+		b, _ := r.Peek(n)
+		c.pending.Write(b)
+	}
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return &acceptConn{ln: ln, server: c.RemoteAddr(), timeout: c.bt.connectTimeout}, nil
+}
+
+// listen listens on the active address, or the local address of the control
+// connection, on the first free port of the active port range
+func (c *ftpControl) listen() (net.Listener, error) {
+	host := c.bt.activeAddress
+	if host == "" {
+		host, _, _ = net.SplitHostPort(c.LocalAddr().String())
+	}
+
+	ports := []int{0}
+	if c.bt.activePortFrom > 0 {
+		ports = nil
+		for port := c.bt.activePortFrom; port <= c.bt.activePortTo; port++ {
+			ports = append(ports, port)
+		}
+	}
+
+	var ln net.Listener
+	var err error
+	for _, port := range ports {
+		ln, err = net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err == nil {
+			return ln, nil
+		}
+	}
+	return nil, err
+}
+
+// acceptGrace is how long closing a data connection the server has not
+// opened yet waits for it. A server opens it before replying to the transfer
+// command, so it is only missing when the command failed.
+const acceptGrace = time.Second
+
+// acceptConn is the data connection the server opens in active mode. The
+// library opens it before sending the transfer command, so the connection is
+// only accepted on its first use.
+type acceptConn struct {
+	ln net.Listener
+	// server is the address of the server on the control connection
+	server  net.Addr
+	timeout time.Duration
+
+	once sync.Once
+	conn net.Conn
+	err  error
+	// deadline is set on the connection once accepted
+	deadline time.Time
+}
+
+func (c *acceptConn) accept(timeout time.Duration) error {
+	c.once.Do(func() {
+		if tcpListener, ok := c.ln.(*net.TCPListener); ok && timeout > 0 {
+			tcpListener.SetDeadline(time.Now().Add(timeout))
+		}
+		c.conn, c.err = c.ln.Accept()
+		c.ln.Close()
+		if c.err == nil && !c.deadline.IsZero() {
+			c.conn.SetDeadline(c.deadline)
+		}
+	})
+	return c.err
+}
+
+func (c *acceptConn) Read(b []byte) (int, error) {
+	if err := c.accept(c.timeout); err != nil {
+		return 0, err
+	}
+	return c.conn.Read(b)
+}
+
+func (c *acceptConn) Write(b []byte) (int, error) {
+	if err := c.accept(c.timeout); err != nil {
+		return 0, err
+	}
+	return c.conn.Write(b)
+}
+
+// Close accepts the connection if it was not, so that the server completes
+// an empty transfer
+func (c *acceptConn) Close() error {
+	timeout := acceptGrace
+	if c.timeout > 0 && c.timeout < timeout {
+		timeout = c.timeout
+	}
+	if err := c.accept(timeout); err != nil {
+		return err
+	}
+	return c.conn.Close()
+}
+
+func (c *acceptConn) LocalAddr() net.Addr {
+	return c.ln.Addr()
+}
+
+// RemoteAddr is the address of the server, the data connection coming from
+// another port or even another address of the server once accepted
+func (c *acceptConn) RemoteAddr() net.Addr {
+	if c.conn != nil {
+		return c.conn.RemoteAddr()
+	}
+	return c.server
+}
+
+func (c *acceptConn) SetDeadline(t time.Time) error {
+	if c.conn != nil {
+		return c.conn.SetDeadline(t)
+	}
+	c.deadline = t
+	return nil
+}
+
+func (c *acceptConn) SetReadDeadline(t time.Time) error {
+	return c.SetDeadline(t)
+}
+
+func (c *acceptConn) SetWriteDeadline(t time.Time) error {
+	return c.SetDeadline(t)
+}
+
+// recordConn copies the data read from the connection
+type recordConn struct {
+	net.Conn
+	w io.Writer
+}
+
+func (c *recordConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.w.Write(b[:n])
+	return n, err
+}
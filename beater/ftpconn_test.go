@@ -0,0 +1,383 @@
+package beater
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ftpStandIn is an FTP server serving files from memory, over passive or
+// active data connections. It records the commands it received and the
+// addresses it connected to in active mode.
+type ftpStandIn struct {
+	ln      net.Listener
+	mutex   sync.Mutex
+	files   map[string]string
+	listing []string
+	// mlsd announces MLST, the listing being then served by MLSD
+	mlsd bool
+	// noOverwrite refuses to rename a file over an existing one
+	noOverwrite bool
+	// missingDir is a directory CWD fails on
+	missingDir string
+	// commands are the commands received, without their arguments
+	commands []string
+	// dialed are the addresses connected to in active mode
+	dialed []string
+}
+
+func newFTPStandIn(t *testing.T, network, address string) *ftpStandIn {
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		t.Skipf("cannot listen on %s: %v", address, err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &ftpStandIn{ln: ln, files: map[string]string{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// beat returns the settings of a passive connection to the stand-in
+func (s *ftpStandIn) beat() *Ftpbeat {
+	host, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	return &Ftpbeat{
+		dialer:          &net.Dialer{Timeout: time.Second},
+		hostname:        host,
+		port:            port,
+		ftpMode:         ftpModePassive,
+		connectTimeout:  time.Second,
+		commandTimeout:  time.Second,
+		remoteDirectory: "/data",
+	}
+}
+
+// connectedBack returns the addresses connected to in active mode
+func (s *ftpStandIn) connectedBack() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.dialed...)
+}
+
+func (s *ftpStandIn) received(command string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	n := 0
+	for _, c := range s.commands {
+		if c == command {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *ftpStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	c := textproto.NewConn(conn)
+	c.PrintfLine("220 stand-in ready")
+
+	var pasv net.Listener
+	var active, renameFrom string
+	defer func() {
+		if pasv != nil {
+			pasv.Close()
+		}
+	}()
+	// data opens the data connection of a transfer the way it was requested
+	data := func() (net.Conn, error) {
+		if active != "" {
+			addr := active
+			active = ""
+			s.mutex.Lock()
+			s.dialed = append(s.dialed, addr)
+			s.mutex.Unlock()
+			return net.Dial("tcp", addr)
+		}
+		if pasv == nil {
+			return nil, fmt.Errorf("no data connection")
+		}
+		defer func() { pasv.Close(); pasv = nil }()
+		return pasv.Accept()
+	}
+	listenPassive := func() (int, error) {
+		if pasv != nil {
+			pasv.Close()
+		}
+		host, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+		var err error
+		pasv, err = net.Listen("tcp", net.JoinHostPort(host, "0"))
+		if err != nil {
+			return 0, err
+		}
+		return pasv.Addr().(*net.TCPAddr).Port, nil
+	}
+
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		command, arg := line, ""
+		if i := strings.Index(line, " "); i >= 0 {
+			command, arg = line[:i], line[i+1:]
+		}
+		s.mutex.Lock()
+		s.commands = append(s.commands, command)
+		s.mutex.Unlock()
+
+		switch command {
+		case "USER":
+			c.PrintfLine("331 password please")
+		case "PASS":
+			c.PrintfLine("230 logged in")
+		case "FEAT":
+			if s.mlsd {
+				c.PrintfLine("211-Features:\r\n MLST type*;size*;modify*;\r\n211 End")
+			} else {
+				c.PrintfLine("211 no features")
+			}
+		case "TYPE", "NOOP":
+			c.PrintfLine("200 ok")
+		case "PWD":
+			c.PrintfLine(`257 "/" is the current directory`)
+		case "CWD":
+			s.mutex.Lock()
+			missing := arg == s.missingDir
+			s.mutex.Unlock()
+			if missing {
+				c.PrintfLine("550 %s: no such directory", arg)
+				continue
+			}
+			c.PrintfLine("250 ok")
+		case "RNFR":
+			s.mutex.Lock()
+			_, ok := s.files[arg]
+			s.mutex.Unlock()
+			if !ok {
+				c.PrintfLine("550 %s: no such file", arg)
+				continue
+			}
+			renameFrom = arg
+			c.PrintfLine("350 ready for RNTO")
+		case "RNTO":
+			s.mutex.Lock()
+			_, exists := s.files[arg]
+			if renameFrom == "" || exists && s.noOverwrite {
+				s.mutex.Unlock()
+				c.PrintfLine("553 cannot rename to %s", arg)
+				continue
+			}
+			s.files[arg] = s.files[renameFrom]
+			delete(s.files, renameFrom)
+			s.mutex.Unlock()
+			renameFrom = ""
+			c.PrintfLine("250 renamed")
+		case "DELE":
+			s.mutex.Lock()
+			_, ok := s.files[arg]
+			delete(s.files, arg)
+			s.mutex.Unlock()
+			if !ok {
+				c.PrintfLine("550 %s: no such file", arg)
+				continue
+			}
+			c.PrintfLine("250 deleted")
+		case "EPSV":
+			port, err := listenPassive()
+			if err != nil {
+				c.PrintfLine("425 %v", err)
+				continue
+			}
+			c.PrintfLine("229 Entering Extended Passive Mode (|||%d|)", port)
+		case "PASV":
+			port, err := listenPassive()
+			if err != nil {
+				c.PrintfLine("425 %v", err)
+				continue
+			}
+			c.PrintfLine("227 Entering Passive Mode (127,0,0,1,%d,%d)", port/256, port%256)
+		case "PORT":
+			var h [6]int
+			if _, err := fmt.Sscanf(arg, "%d,%d,%d,%d,%d,%d", &h[0], &h[1], &h[2], &h[3], &h[4], &h[5]); err != nil {
+				c.PrintfLine("501 %v", err)
+				continue
+			}
+			active = net.JoinHostPort(fmt.Sprintf("%d.%d.%d.%d", h[0], h[1], h[2], h[3]), strconv.Itoa(h[4]*256+h[5]))
+			c.PrintfLine("200 PORT ok")
+		case "EPRT":
+			fields := strings.Split(arg, "|")
+			if len(fields) != 5 {
+				c.PrintfLine("501 bad EPRT")
+				continue
+			}
+			active = net.JoinHostPort(fields[2], fields[3])
+			c.PrintfLine("200 EPRT ok")
+		case "LIST", "MLSD", "RETR":
+			s.mutex.Lock()
+			content, ok := s.files[arg]
+			if command != "RETR" {
+				content, ok = strings.Join(s.listing, "\r\n")+"\r\n", true
+			}
+			s.mutex.Unlock()
+			if !ok {
+				active = ""
+				c.PrintfLine("550 %s: no such file", arg)
+				continue
+			}
+			dc, err := data()
+			if err != nil {
+				c.PrintfLine("425 %v", err)
+				continue
+			}
+			c.PrintfLine("150 opening data connection")
+			dc.Write([]byte(content))
+			dc.Close()
+			c.PrintfLine("226 transfer complete")
+		case "STOR":
+			dc, err := data()
+			if err != nil {
+				c.PrintfLine("425 %v", err)
+				continue
+			}
+			c.PrintfLine("150 opening data connection")
+			b, _ := ioutil.ReadAll(dc)
+			dc.Close()
+			s.mutex.Lock()
+			s.files[arg] = string(b)
+			s.mutex.Unlock()
+			c.PrintfLine("226 transfer complete")
+		case "QUIT":
+			c.PrintfLine("221 bye")
+			return
+		default:
+			c.PrintfLine("502 %s not implemented", command)
+		}
+	}
+}
+
+func TestFTPDataConnections(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string
+		address string
+		mode    string
+		// command announces the active data connections
+		command string
+	}{
+		{"passive", "tcp4", "127.0.0.1:0", ftpModePassive, ""},
+		{"active IPv4", "tcp4", "127.0.0.1:0", ftpModeActive, "PORT"},
+		{"active IPv6", "tcp6", "[::1]:0", ftpModeActive, "EPRT"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			standIn := newFTPStandIn(t, test.network, test.address)
+			standIn.files["report.csv"] = "a,b\n1,2\n"
+			standIn.listing = []string{"-rw-r--r--    1 ftp      ftp             8 Jan 02  2006 report.csv"}
+			bt := standIn.beat()
+			bt.ftpMode = test.mode
+
+			f := &stFTP{}
+			if err := f.Init(bt); err != nil {
+				t.Fatal(err)
+			}
+			defer f.Quit()
+			if err := f.Login(bt); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := f.con.List(".")
+			if err != nil || len(entries) != 1 || entries[0].Name != "report.csv" {
+				t.Fatalf("List() = %v, %v", entries, err)
+			}
+			r, err := f.Open(remoteFile{Name: "report.csv"}, bt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadAll(r)
+			if err != nil || string(b) != "a,b\n1,2\n" {
+				t.Fatalf("read %q, %v", b, err)
+			}
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// A failed transfer leaves the control connection usable
+			if _, err := f.Open(remoteFile{Name: "missing.csv"}, bt); err == nil {
+				t.Fatalf("missing file opened")
+			}
+			if err := f.KeepAlive(); err != nil {
+				t.Fatalf("control connection broken after a failed transfer: %v", err)
+			}
+
+			if test.command != "" {
+				if n := standIn.received(test.command); n != 3 {
+					t.Errorf("%d %s commands, want 3", n, test.command)
+				}
+				if dialed := standIn.connectedBack(); len(dialed) != 2 {
+					t.Errorf("server connected back %d times, want 2", len(dialed))
+				}
+			} else if standIn.received("PORT")+standIn.received("EPRT") > 0 {
+				t.Errorf("active data connection in passive mode")
+			}
+		})
+	}
+}
+
+// In active mode the data connections are listened for on the first free
+// port of the range
+func TestFTPActivePortRange(t *testing.T) {
+	standIn := newFTPStandIn(t, "tcp4", "127.0.0.1:0")
+	standIn.files["report.csv"] = "a,b\n"
+	bt := standIn.beat()
+	bt.ftpMode = ftpModeActive
+
+	busy, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	bt.activePortFrom = busy.Addr().(*net.TCPAddr).Port
+	bt.activePortTo = bt.activePortFrom + 10
+
+	f := &stFTP{}
+	if err := f.Init(bt); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Quit()
+	if err := f.Login(bt); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		r, err := f.Open(remoteFile{Name: "report.csv"}, bt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(r)
+		r.Close()
+	}
+
+	dialed := standIn.connectedBack()
+	for _, addr := range dialed {
+		_, port, _ := net.SplitHostPort(addr)
+		n, _ := strconv.Atoi(port)
+		if n <= bt.activePortFrom || n > bt.activePortTo {
+			t.Errorf("data connection on port %d, want %d to %d without the busy one", n, bt.activePortFrom, bt.activePortTo)
+		}
+	}
+	if len(dialed) != 2 {
+		t.Errorf("server connected back %d times, want 2", len(dialed))
+	}
+}
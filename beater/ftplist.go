@@ -0,0 +1,283 @@
+package beater
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+)
+
+// The FTP listings are parsed by the ftp library. The lines it skips are
+// parsed here when they come from IIS with four digit years or a 24-hour
+// clock, or from OpenVMS, and the permissions and owner of the files it
+// drops are read from the ls and MLSD lines.
+
+var errUnsupportedListLine = errors.New("Unsupported LIST line")
+
+// ftpEntry is a line of a MLSD or LIST listing
+type ftpEntry struct {
+	Name  string
+	Type  ftp.EntryType
+	Size  uint64
+	Time  time.Time
+	Mode  os.FileMode
+	Owner string
+	Group string
+
+	// SizeKnown tells whether the line has the size
+	SizeKnown bool
+}
+
+// ftpOwnership is what the library drops of an ls or MLSD line
+type ftpOwnership struct {
+	mode      os.FileMode
+	owner     string
+	group     string
+	sizeKnown bool
+}
+
+// iisTimeFormats are the DIR formats the library does not know, with four
+// digit years or a 24-hour clock. The 12-hour clock formats must come before
+// the 24-hour ones as the latter would match their prefix.
+var iisTimeFormats = []string{
+	"01-02-2006  03:04PM",
+	"01-02-06  15:04",
+	"01-02-2006  15:04",
+}
+
+// dirDateFormat is the longest DIR date the library knows
+const dirDateFormat = "01-02-06  03:04PM"
+
+// vmsBlockSize is the size of the disk blocks OpenVMS reports file sizes in
+const vmsBlockSize = 512
+
+// listEntries completes the entries the library parsed from the listing
+// with the lines it skipped and the ownership of the files. The dates of the
+// lines parsed here are in the location, like those the library parses.
+func listEntries(parsed []*ftp.Entry, listing []byte, location *time.Location) []*ftpEntry {
+	ownership := map[string]ftpOwnership{}
+	malformed := map[string]bool{}
+	var skipped []*ftpEntry
+	scan := bufio.NewScanner(bytes.NewReader(listing))
+	for scan.Scan() {
+		line := strings.TrimRight(scan.Text(), "\r")
+		if name, o, ok := parseOwnership(line); ok {
+			ownership[name] = o
+		} else if e, err := parseSkippedListLine(line, location); err == nil {
+			skipped = append(skipped, e)
+		} else if name, ok := shortDirLine(line); ok {
+			malformed[name] = true
+		}
+	}
+
+	var entries []*ftpEntry
+	for _, entry := range parsed {
+		if malformed[entry.Name] && entry.Time.IsZero() {
+			continue
+		}
+		e := &ftpEntry{
+			Name:      entry.Name,
+			Type:      entry.Type,
+			Size:      entry.Size,
+			Time:      entry.Time,
+			SizeKnown: entry.Type == ftp.EntryTypeFile,
+		}
+		if o, ok := ownership[entry.Name]; ok {
+			e.Mode, e.Owner, e.Group = o.mode, o.owner, o.group
+			e.SizeKnown = e.SizeKnown && o.sizeKnown
+		}
+		entries = append(entries, e)
+	}
+	return append(entries, skipped...)
+}
+
+// parseSkippedListLine parses a line in one of the formats the library does
+// not know
+func parseSkippedListLine(line string, location *time.Location) (*ftpEntry, error) {
+	for _, f := range []func(string, *time.Location) (*ftpEntry, error){parseIISListLine, parseVMSListLine} {
+		e, err := f(line, location)
+		if err != errUnsupportedListLine {
+			return e, err
+		}
+	}
+	return nil, errUnsupportedListLine
+}
+
+// shortDirLine returns the name the library gives to a line too short to
+// start with a DIR date, which it takes for a DIR line without a date like
+// "123 x"
+func shortDirLine(line string) (string, bool) {
+	line = strings.TrimLeft(line, " ")
+	if len(line) > len(dirDateFormat) {
+		return "", false
+	}
+	space := strings.Index(line, " ")
+	if space < 0 {
+		return "", false
+	}
+	return strings.TrimLeft(line[space:], " "), true
+}
+
+// parseIISListLine parses a directory line of the Windows IIS FTP server
+// with a four digit year or a 24-hour clock.
+// 10-18-2026  06:00AM                 1234 settlement.csv
+// 10-18-26  22:00       <DIR>          sub
+func parseIISListLine(line string, location *time.Location) (*ftpEntry, error) {
+	e := &ftpEntry{}
+	err := errUnsupportedListLine
+	for _, format := range iisTimeFormats {
+		if len(line) > len(format) {
+			e.Time, err = time.ParseInLocation(format, line[:len(format)], location)
+			if err == nil {
+				line = line[len(format):]
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, errUnsupportedListLine
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return nil, errUnsupportedListLine
+	}
+	if fields[0] == "<DIR>" {
+		e.Type = ftp.EntryTypeFolder
+	} else {
+		e.Size, err = strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return nil, errUnsupportedListLine
+		}
+		e.SizeKnown = true
+		e.Type = ftp.EntryTypeFile
+	}
+	e.Name = strings.TrimLeft(line, " ")[len(fields[0]):]
+	e.Name = strings.TrimLeft(e.Name, " ")
+	return e, nil
+}
+
+// parseVMSListLine parses a directory line of the OpenVMS FTP server. The
+// version is stripped from the name and the size, given in blocks, is
+// converted to bytes.
+// SETTLEMENT.CSV;3          12/18      18-OCT-2026 06:00:12  [GROUP,OWNER]  (RWED,RWED,RE,)
+func parseVMSListLine(line string, location *time.Location) (*ftpEntry, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return nil, errUnsupportedListLine
+	}
+
+	iVersion := strings.LastIndex(fields[0], ";")
+	if iVersion < 1 {
+		return nil, errUnsupportedListLine
+	}
+	if _, err := strconv.Atoi(fields[0][iVersion+1:]); err != nil {
+		return nil, errUnsupportedListLine
+	}
+
+	e := &ftpEntry{
+		Name: fields[0][:iVersion],
+		Type: ftp.EntryTypeFile,
+	}
+	if strings.HasSuffix(strings.ToUpper(e.Name), ".DIR") {
+		e.Type = ftp.EntryTypeFolder
+		e.Name = e.Name[:len(e.Name)-len(".DIR")]
+	}
+
+	// Size is either "used" or "used/allocated" blocks
+	blocks := fields[1]
+	if i := strings.Index(blocks, "/"); i >= 0 {
+		blocks = blocks[:i]
+	}
+	size, err := strconv.ParseUint(blocks, 10, 64)
+	if err != nil {
+		return nil, errUnsupportedListLine
+	}
+	e.Size = size * vmsBlockSize
+	e.SizeKnown = true
+
+	date := fields[2]
+	if len(fields) > 3 && strings.Contains(fields[3], ":") {
+		date += " " + fields[3]
+	}
+	for _, format := range []string{"2-Jan-2006 15:04:05", "2-Jan-2006 15:04", "2-Jan-2006"} {
+		if e.Time, err = time.ParseInLocation(format, strings.Title(strings.ToLower(date)), location); err == nil {
+			return e, nil
+		}
+	}
+	return nil, errUnsupportedListLine
+}
+
+// parseOwnership returns the name of the file of an ls or MLSD line with its
+// permissions and owner
+func parseOwnership(line string) (string, ftpOwnership, bool) {
+	var o ftpOwnership
+
+	// MLSD facts come before the first space, separated by semicolons
+	if space := strings.Index(line, " "); space > 0 && strings.HasSuffix(line[:space], ";") {
+		for _, fact := range strings.Split(line[:space-1], ";") {
+			i := strings.Index(fact, "=")
+			if i < 1 {
+				return "", o, false
+			}
+			value := fact[i+1:]
+			switch strings.ToLower(fact[:i]) {
+			case "size":
+				o.sizeKnown = true
+			case "unix.mode":
+				if mode, err := strconv.ParseUint(value, 8, 32); err == nil {
+					o.mode = os.FileMode(mode).Perm()
+				}
+			case "unix.owner", "unix.uid":
+				o.owner = value
+			case "unix.group", "unix.gid":
+				o.group = value
+			}
+		}
+		return line[space+1:], o, true
+	}
+
+	// ls lines have the mode, links, owner, group, size and a three field
+	// date before the name, unlike the "folder" and link count 0 variants
+	fields := strings.Fields(line)
+	if len(fields) < 9 || len(fields[0]) < 10 || fields[1] == "folder" || fields[1] == "0" {
+		return "", o, false
+	}
+	if !strings.ContainsRune("-dl", rune(fields[0][0])) {
+		return "", o, false
+	}
+	name := line
+	for i := 0; i < 8; i++ {
+		name = strings.TrimLeft(name, " ")
+		space := strings.Index(name, " ")
+		if space < 0 {
+			return "", o, false
+		}
+		name = name[space:]
+	}
+	name = name[1:]
+	if i := strings.Index(name, " -> "); fields[0][0] == 'l' && i > 0 {
+		name = name[:i]
+	}
+
+	o.mode = parseLsMode(fields[0])
+	o.owner, o.group = fields[2], fields[3]
+	o.sizeKnown = true
+	return name, o, true
+}
+
+// parseLsMode parses the permissions of a "-rwxr-xr-x" mode string
+func parseLsMode(s string) os.FileMode {
+	var mode os.FileMode
+	for i, c := range s[1:10] {
+		if c != '-' {
+			mode |= 1 << uint(8-i)
+		}
+	}
+	return mode
+}
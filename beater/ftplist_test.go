@@ -0,0 +1,255 @@
+package beater
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+)
+
+// tokyo is the timezone of the servers in the tests, the dates of the
+// listings being in the timezone of the server
+var tokyo = time.FixedZone("JST", 9*60*60)
+
+var skippedListTests = []struct {
+	line      string
+	name      string
+	size      uint64
+	entryType ftp.EntryType
+	time      time.Time
+}{
+	// IIS with four digit years and 24-hour clock
+	{"10-18-2026  06:00AM                 1234 settlement_20261018.csv", "settlement_20261018.csv", 1234, ftp.EntryTypeFile, time.Date(2026, time.October, 18, 6, 0, 0, 0, tokyo)},
+	{"10-18-2026  06:00PM                 1234 settlement_20261018.csv", "settlement_20261018.csv", 1234, ftp.EntryTypeFile, time.Date(2026, time.October, 18, 18, 0, 0, 0, tokyo)},
+	{"10-18-26  22:15                     42 trades.csv", "trades.csv", 42, ftp.EntryTypeFile, time.Date(2026, time.October, 18, 22, 15, 0, 0, tokyo)},
+	{"10-18-2026  22:15       <DIR>          archive", "archive", 0, ftp.EntryTypeFolder, time.Date(2026, time.October, 18, 22, 15, 0, 0, tokyo)},
+	{"10-18-2026  22:15                    7 two  spaces.csv", "two  spaces.csv", 7, ftp.EntryTypeFile, time.Date(2026, time.October, 18, 22, 15, 0, 0, tokyo)},
+
+	// OpenVMS
+	{"SETTLEMENT.CSV;3          12/18      18-OCT-2026 06:00:12  [GROUP,OWNER]  (RWED,RWED,RE,)", "SETTLEMENT.CSV", 12 * 512, ftp.EntryTypeFile, time.Date(2026, time.October, 18, 6, 0, 12, 0, tokyo)},
+	{"ARCHIVE.DIR;1              1         2-JAN-2026 10:00  [GROUP,OWNER]  (RWE,RWE,RE,E)", "ARCHIVE", 512, ftp.EntryTypeFolder, time.Date(2026, time.January, 2, 10, 0, 0, 0, tokyo)},
+	{"LOGIN.COM;12               3         2-JAN-2026", "LOGIN.COM", 3 * 512, ftp.EntryTypeFile, time.Date(2026, time.January, 2, 0, 0, 0, 0, tokyo)},
+}
+
+func TestParseSkippedListLine(t *testing.T) {
+	for _, lt := range skippedListTests {
+		entry, err := parseSkippedListLine(lt.line, tokyo)
+		if err != nil {
+			t.Errorf("parseSkippedListLine(%v) returned err = %v", lt.line, err)
+			continue
+		}
+		if entry.Name != lt.name {
+			t.Errorf("parseSkippedListLine(%v).Name = '%v', want '%v'", lt.line, entry.Name, lt.name)
+		}
+		if entry.Type != lt.entryType {
+			t.Errorf("parseSkippedListLine(%v).EntryType = %v, want %v", lt.line, entry.Type, lt.entryType)
+		}
+		if entry.Size != lt.size || (lt.entryType == ftp.EntryTypeFile && !entry.SizeKnown) {
+			t.Errorf("parseSkippedListLine(%v).Size = %v, want %v", lt.line, entry.Size, lt.size)
+		}
+		if !entry.Time.Equal(lt.time) {
+			t.Errorf("parseSkippedListLine(%v).Time = %v, want %v", lt.line, entry.Time, lt.time)
+		}
+	}
+}
+
+// Malformed lines and the formats the library parses are rejected
+var skippedListTestsFail = []string{
+	"",
+	"123 x",
+	"total 1",
+	"10-18-2026",
+	"10-18-2026  22:15",
+	"10-18-2026  22:15   ",
+	"10-18-2026  22:15   1234",
+	"10-18-2026  22:15   abc file.csv",
+	"13-45-2026  22:15   1234 file.csv",
+	"SETTLEMENT.CSV;3",
+	"SETTLEMENT.CSV;x          12/18      18-OCT-2026 06:00:12",
+	"SETTLEMENT.CSV;3          many       18-OCT-2026 06:00:12",
+	"SETTLEMENT.CSV;3          12/18      yesterday",
+	";3          12/18      18-OCT-2026 06:00:12",
+	"08-07-15  07:50PM                  718 Post_PRR_20150901_1166_265118_13049.dat",
+	"2015-08-07  19:50                  718 Post_PRR_20150901_1166_265118_13049.dat",
+	"-rw-r--r--   1 marketwired marketwired    12016 Mar 16  2016 2016031611G087802-001.newsml",
+	"modify=20150813175250;perm=adfr;size=951;type=file;unique=119FBB87UE; welcome.msg",
+}
+
+func TestParseSkippedListLineFail(t *testing.T) {
+	for _, line := range skippedListTestsFail {
+		if e, err := parseSkippedListLine(line, tokyo); err == nil {
+			t.Errorf("parseSkippedListLine(%v) = %+v, expected to fail", line, e)
+		}
+	}
+}
+
+var ownershipTests = []struct {
+	line      string
+	name      string
+	mode      os.FileMode
+	owner     string
+	group     string
+	sizeKnown bool
+}{
+	{"-rw-r-----   1 marketwired marketwired    12016 Mar 16  2016 2016031611G087802-001.newsml", "2016031611G087802-001.newsml", 0640, "marketwired", "marketwired", true},
+	{"drwxr-xr-x    3 110      1002            3 Dec 02  2009 pub", "pub", 0755, "110", "1002", true},
+	{"-rwxr-xr-x    3 110      1002            1234567 Dec 02  2009  foo bar ", " foo bar ", 0755, "110", "1002", true},
+	{"lrwxrwxrwx   1 root     other          7 Jan 25 00:17 bin -> usr/bin", "bin", 0777, "root", "other", true},
+	{"modify=20150813175250;perm=adfr;size=951;type=file;unique=119FBB87UE;UNIX.group=100;UNIX.mode=0644;UNIX.owner=1000; welcome.msg", "welcome.msg", 0644, "1000", "100", true},
+	{"type=file;modify=20161018060000; b.log", "b.log", 0, "", "", false},
+}
+
+func TestParseOwnership(t *testing.T) {
+	for _, ot := range ownershipTests {
+		name, o, ok := parseOwnership(ot.line)
+		if !ok {
+			t.Errorf("parseOwnership(%v) failed", ot.line)
+			continue
+		}
+		if name != ot.name || o.mode != ot.mode || o.owner != ot.owner || o.group != ot.group || o.sizeKnown != ot.sizeKnown {
+			t.Errorf("parseOwnership(%v) = %q %v %s:%s %v, want %q %v %s:%s %v", ot.line, name, o.mode, o.owner, o.group, o.sizeKnown,
+				ot.name, ot.mode, ot.owner, ot.group, ot.sizeKnown)
+		}
+	}
+
+	for _, line := range []string{"", "123 x", "total 1", "08-07-15  07:50PM    718 a.dat", "drwxrwxrwx               folder        0 Aug 11 20:32 P0RN"} {
+		if _, _, ok := parseOwnership(line); ok {
+			t.Errorf("parseOwnership(%v) expected to fail", line)
+		}
+	}
+}
+
+// The listings are read through the library, the lines it skips and the
+// ownership of the files being added
+func TestFTPListing(t *testing.T) {
+	standIn := newFTPStandIn(t, "tcp4", "127.0.0.1:0")
+	standIn.listing = []string{
+		"total 4",
+		"-rw-r-----   1 ftp      staff          6 Mar 16  2016 a.log",
+		"drwxr-xr-x   1 ftp      staff          0 Mar 16  2016 sub",
+		"08-07-15  07:50PM                  718 dos.dat",
+		"10-18-2026  22:15                   42 iis.csv",
+		"SETTLEMENT.CSV;3          12/18      18-OCT-2026 06:00:12  [GROUP,OWNER]  (RWED,RWED,RE,)",
+		"123 x",
+		"7 short.csv",
+	}
+	bt := standIn.beat()
+	bt.location = tokyo
+
+	f := &stFTP{}
+	if err := f.Init(bt); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Quit()
+	if err := f.Login(bt); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := f.list(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ftpEntry{
+		{Name: "a.log", Type: ftp.EntryTypeFile, Size: 6, Time: time.Date(2016, time.March, 16, 0, 0, 0, 0, tokyo), Mode: 0640, Owner: "ftp", Group: "staff", SizeKnown: true},
+		{Name: "sub", Type: ftp.EntryTypeFolder, Time: time.Date(2016, time.March, 16, 0, 0, 0, 0, tokyo), Mode: 0755, Owner: "ftp", Group: "staff"},
+		{Name: "dos.dat", Type: ftp.EntryTypeFile, Size: 718, Time: time.Date(2015, time.August, 7, 19, 50, 0, 0, tokyo), SizeKnown: true},
+		{Name: "iis.csv", Type: ftp.EntryTypeFile, Size: 42, Time: time.Date(2026, time.October, 18, 22, 15, 0, 0, tokyo), SizeKnown: true},
+		{Name: "SETTLEMENT.CSV", Type: ftp.EntryTypeFile, Size: 12 * 512, Time: time.Date(2026, time.October, 18, 6, 0, 12, 0, tokyo), SizeKnown: true},
+	}
+	if len(entries) != len(want) {
+		for _, e := range entries {
+			t.Logf("%+v", *e)
+		}
+		t.Fatalf("%d entries, want %d", len(entries), len(want))
+	}
+	for i, e := range entries {
+		w := want[i]
+		if e.Name != w.Name || e.Type != w.Type || e.Size != w.Size || !e.Time.Equal(w.Time) ||
+			e.Mode != w.Mode || e.Owner != w.Owner || e.Group != w.Group || e.SizeKnown != w.SizeKnown {
+			t.Errorf("entry %d = %+v, want %+v", i, *e, w)
+		}
+	}
+}
+
+// A MLSD line may have no size
+func TestFTPListingMLSD(t *testing.T) {
+	standIn := newFTPStandIn(t, "tcp4", "127.0.0.1:0")
+	standIn.mlsd = true
+	standIn.listing = []string{
+		"modify=20161018060000;size=6;type=file;UNIX.mode=0640;UNIX.owner=ftp;UNIX.group=staff; a.log",
+		"modify=20161018060000;type=file; b.log",
+	}
+	bt := standIn.beat()
+	bt.location = tokyo
+
+	f := &stFTP{}
+	if err := f.Init(bt); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Quit()
+	if err := f.Login(bt); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := f.list(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if standIn.received("MLSD") != 1 || len(entries) != 2 {
+		t.Fatalf("%d entries listed with %d MLSD", len(entries), standIn.received("MLSD"))
+	}
+	a, b := entries[0], entries[1]
+	if a.Name != "a.log" || a.Size != 6 || !a.SizeKnown || a.Mode != 0640 || a.Owner != "ftp" || a.Group != "staff" {
+		t.Errorf("a.log = %+v", *a)
+	}
+	if !a.Time.Equal(time.Date(2016, time.October, 18, 6, 0, 0, 0, tokyo)) {
+		t.Errorf("a.log modified %v, want 2016-10-18 06:00 JST", a.Time)
+	}
+	if b.Name != "b.log" || b.SizeKnown {
+		t.Errorf("b.log = %+v", *b)
+	}
+}
+
+// ls shows the time rather than the year of the files of the last six
+// months, the year being the current one or, for dates that would then be
+// more than six months ahead, the previous one
+func TestFTPListingYearRollover(t *testing.T) {
+	now := time.Now().In(tokyo)
+	dates := []time.Time{
+		now.AddDate(0, 0, -1),
+		now.AddDate(0, -3, 0),
+		now.AddDate(0, -5, 0),
+		now.AddDate(0, 0, 1),
+	}
+
+	standIn := newFTPStandIn(t, "tcp4", "127.0.0.1:0")
+	for i, d := range dates {
+		standIn.listing = append(standIn.listing,
+			fmt.Sprintf("-rw-r--r--   1 ftp      ftp            1 %s file%d", d.Format("Jan _2 15:04"), i))
+	}
+	bt := standIn.beat()
+	bt.location = tokyo
+
+	f := &stFTP{}
+	if err := f.Init(bt); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Quit()
+	if err := f.Login(bt); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := f.list(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(dates) {
+		t.Fatalf("%d entries, want %d", len(entries), len(dates))
+	}
+	for i, d := range dates {
+		want := time.Date(d.Year(), d.Month(), d.Day(), d.Hour(), d.Minute(), 0, 0, tokyo)
+		if !entries[i].Time.Equal(want) {
+			t.Errorf("%s modified %v, want %v", entries[i].Name, entries[i].Time, want)
+		}
+	}
+}
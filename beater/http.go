@@ -0,0 +1,416 @@
+package beater
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/logp"
+	"golang.org/x/net/html"
+)
+
+// supported HTTP authentications
+const (
+	authNone   = "none"
+	authBasic  = "basic"
+	authBearer = "bearer"
+)
+
+// newHTTPClient returns the client of the runners built on HTTP. It goes
+// through the proxy and times out connections that stop sending like the
+// other runners do.
+func newHTTPClient(bt *Ftpbeat) *http.Client {
+	timeout := bt.commandTimeout
+	if bt.idleReadTimeout > timeout {
+		timeout = bt.idleReadTimeout
+	}
+	transport := &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			conn, err := bt.dialer.Dial(network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return newDeadlineConn(conn, timeout, true), nil
+		},
+		TLSClientConfig:       bt.tlsConfig,
+		TLSHandshakeTimeout:   bt.connectTimeout,
+		ResponseHeaderTimeout: bt.commandTimeout,
+		MaxIdleConnsPerHost:   bt.maxTransfers,
+	}
+	return &http.Client{Transport: transport}
+}
+
+// closeIdleConnections closes the pooled connections of the client
+func closeIdleConnections(client *http.Client) {
+	if transport, ok := client.Transport.(*http.Transport); ok {
+		transport.CloseIdleConnections()
+	}
+}
+
+// partialCopy is where an aborted copy stopped, and the validator of the
+// file it was copying
+type partialCopy struct {
+	offset    int64
+	validator string
+}
+
+// copyResumable copies a file served over HTTP into the current directory.
+// A copy aborted by a broken connection goes on where it stopped with a
+// ranged GET when retried, unless the file has changed in between.
+func copyResumable(bt *Ftpbeat, file remoteFile, partials map[string]partialCopy,
+	get func(offset int64, validator string) (*http.Response, error)) error {
+
+	partial := partials[file.Name]
+	delete(partials, file.Name)
+
+	resp, err := get(partial.offset, partial.validator)
+	if err != nil && partial.offset > 0 {
+		logp.Warn("Cannot resume %s, copying it again: %v", file.Name, err)
+		partial = partialCopy{}
+		resp, err = get(0, "")
+	}
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+		return err
+	}
+	defer resp.Body.Close()
+
+	n, err := bt.writeDownload(file.Name, bt.throttle(resp.Body), partial.offset > 0)
+	if err != nil {
+		// A download broken by the server is kept to be resumed, not one
+		// the local file failed
+		if _, local := err.(*os.PathError); !local {
+			validator := resp.Header.Get("ETag")
+			if validator == "" {
+				validator = resp.Header.Get("Last-Modified")
+			}
+			partials[file.Name] = partialCopy{partial.offset + n, validator}
+		}
+		logp.Err("%v : %s", err, file.Name)
+		return err
+	}
+	if err = bt.finishDownload(file.Name); err != nil {
+		logp.Err("%v : %s", err, file.Name)
+	}
+	return err
+}
+
+// stHTTP reads the files of a directory published over HTTP, listed with
+// PROPFIND on a WebDAV share or from the links of a directory index page
+type stHTTP struct {
+	client   *http.Client
+	webdav   bool
+	partials map[string]partialCopy
+}
+
+func (f *stHTTP) Init(bt *Ftpbeat) error {
+	f.client = newHTTPClient(bt)
+	f.webdav = bt.connectType == ctWebDAV
+	return nil
+}
+
+// Login checks the directory can be accessed with the credentials
+func (f *stHTTP) Login(bt *Ftpbeat) error {
+	err := f.probe(bt)
+	if err != nil {
+		logp.Err("%v", err)
+	}
+	return err
+}
+
+func (f *stHTTP) CheckFiles(bt *Ftpbeat) ([]remoteFile, error) {
+	var listing []remoteFile
+	var err error
+	if f.webdav {
+		listing, err = f.propfind(bt)
+	} else {
+		listing, err = f.index(bt)
+	}
+	if err != nil {
+		logp.Err("%v", err)
+		return nil, err
+	}
+
+	files := bt.selectRemote(listing)
+	logp.Info("Files : %v", fileNames(files))
+	return files, nil
+}
+
+// Session shares the client, which pools its connections
+func (f *stHTTP) Session(bt *Ftpbeat) (integratedFunc, error) {
+	return &stHTTP{client: f.client, webdav: f.webdav}, nil
+}
+
+// Reconnect drops the pooled connections, the aborted copies are kept to be
+// resumed
+func (f *stHTTP) Reconnect(bt *Ftpbeat) error {
+	f.Quit()
+	return nil
+}
+
+// KeepAlive always succeeds as every request gets a working connection from
+// the pool, only the transfers cut short are retried
+func (f *stHTTP) KeepAlive() error {
+	return nil
+}
+
+func (f *stHTTP) GenEventForLocalFile(file remoteFile, bt *Ftpbeat, b *beat.Beat) error {
+	return genEventForLocalFile(file, bt)
+}
+
+func (f *stHTTP) GenEvent(file remoteFile, bt *Ftpbeat, b *beat.Beat) error {
+	resp, err := f.get(bt, file.Name, 0, "")
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+		return err
+	}
+
+	// A directory index has no sizes nor times, the response tells them
+	if !file.SizeKnown && resp.ContentLength >= 0 {
+		file.Size = resp.ContentLength
+		file.SizeKnown = true
+	}
+	if file.ModTime.IsZero() {
+		if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+			file.ModTime = t
+		}
+	}
+
+	err = publishLines(bt, file, bt.throttle(resp.Body))
+	resp.Body.Close()
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+	}
+	return err
+}
+
+func (f *stHTTP) CopyFiles(file remoteFile, bt *Ftpbeat) error {
+	if f.partials == nil {
+		f.partials = make(map[string]partialCopy)
+	}
+	return copyResumable(bt, file, f.partials, func(offset int64, validator string) (*http.Response, error) {
+		return f.get(bt, file.Name, offset, validator)
+	})
+}
+
+// Open returns the contents of the remote file
+func (f *stHTTP) Open(file remoteFile, bt *Ftpbeat) (io.ReadCloser, error) {
+	resp, err := f.get(bt, file.Name, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Quit closes the idle pooled connections
+func (f *stHTTP) Quit() {
+	if f.client != nil {
+		closeIdleConnections(f.client)
+	}
+}
+
+// probe checks the directory is there
+func (f *stHTTP) probe(bt *Ftpbeat) error {
+	if f.webdav {
+		header := http.Header{"Depth": {"0"}}
+		resp, err := f.do(bt, "PROPFIND", bt.httpURL, header, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	resp, err := f.do(bt, "HEAD", bt.httpURL, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// get returns the contents of the file from offset on. Given the validator
+// of the file, the server sends the whole file if it changed since, which
+// is an error when resuming.
+func (f *stHTTP) get(bt *Ftpbeat, name string, offset int64, validator string) (*http.Response, error) {
+	header := make(http.Header)
+	if offset > 0 {
+		header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+		if validator != "" {
+			header.Set("If-Range", validator)
+		}
+	}
+	resp, err := f.do(bt, "GET", bt.httpURL.ResolveReference(&url.URL{Path: name}), header, nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s changed or range requests not supported", name)
+	}
+	return resp, nil
+}
+
+// do sends an authenticated request and turns error statuses into errors
+func (f *stHTTP) do(bt *Ftpbeat, method string, u *url.URL, header http.Header, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	switch bt.httpAuth {
+	case authBasic:
+		req.SetBasicAuth(bt.username, bt.password)
+	case authBearer:
+		req.Header.Set("Authorization", "Bearer "+bt.httpBearerToken)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s", method, u, resp.Status)
+	}
+	return resp, nil
+}
+
+// propfindBody asks for the properties of the files of the collection
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/><D:getcontentlength/><D:getlastmodified/></D:prop></D:propfind>`
+
+// davMultistatus is the answer to a PROPFIND
+type davMultistatus struct {
+	Responses []struct {
+		Href      string `xml:"DAV: href"`
+		Propstats []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				Collection    *struct{} `xml:"DAV: resourcetype>collection"`
+				ContentLength *int64    `xml:"DAV: getcontentlength"`
+				LastModified  string    `xml:"DAV: getlastmodified"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// propfind lists the files of the WebDAV collection
+func (f *stHTTP) propfind(bt *Ftpbeat) ([]remoteFile, error) {
+	header := http.Header{
+		"Depth":        {"1"},
+		"Content-Type": {"application/xml; charset=utf-8"},
+	}
+	resp, err := f.do(bt, "PROPFIND", bt.httpURL, header, strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var ms davMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("PROPFIND %s: invalid response: %v", redactURL(bt.httpURL.String()), err)
+	}
+
+	var listing []remoteFile
+	for _, r := range ms.Responses {
+		name, ok := childName(bt.httpURL, r.Href)
+		if !ok {
+			continue
+		}
+		for _, ps := range r.Propstats {
+			if !strings.Contains(ps.Status, " 200 ") || ps.Prop.Collection != nil {
+				continue
+			}
+			file := remoteFile{Name: name}
+			if ps.Prop.ContentLength != nil {
+				file.Size, file.SizeKnown = *ps.Prop.ContentLength, true
+			}
+			if t, err := http.ParseTime(ps.Prop.LastModified); err == nil {
+				file.ModTime = t
+			}
+			listing = append(listing, file)
+		}
+	}
+	return listing, nil
+}
+
+// index lists the files linked from the directory index page
+func (f *stHTTP) index(bt *Ftpbeat) ([]remoteFile, error) {
+	resp, err := f.do(bt, "GET", bt.httpURL, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	doc, err := html.Parse(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: invalid index: %v", redactURL(bt.httpURL.String()), err)
+	}
+
+	var listing []remoteFile
+	seen := make(map[string]bool)
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "a" {
+			for _, attr := range n.Attr {
+				if attr.Key != "href" {
+					continue
+				}
+				// Links to other pages, sorting links and sub directories
+				// are no files of the directory
+				name, ok := childName(bt.httpURL, attr.Val)
+				if ok && !seen[name] {
+					seen[name] = true
+					listing = append(listing, remoteFile{Name: name})
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return listing, nil
+}
+
+// childName returns the name of the file a link points to when it is
+// directly in the directory, sub directories excluded
+func childName(dir *url.URL, href string) (string, bool) {
+	ref, err := url.Parse(href)
+	if err != nil || ref.RawQuery != "" {
+		return "", false
+	}
+	u := dir.ResolveReference(ref)
+	if u.Host != dir.Host || !strings.HasPrefix(u.Path, dir.Path) {
+		return "", false
+	}
+	name := u.Path[len(dir.Path):]
+	if name == "" || strings.Contains(name, "/") || path.Clean(name) != name {
+		return "", false
+	}
+	return name, true
+}
+
+// parseHTTPURL parses the URL of the directory, which always ends with a
+// slash for the file names to resolve under it
+func parseHTTPURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("Invalid URL [%s], expected http(s)://host[:port]/path/", redactURL(s))
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+		u.RawPath = ""
+	}
+	return u, nil
+}
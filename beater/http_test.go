@@ -0,0 +1,227 @@
+package beater
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/affinity226/ftpbeat/config"
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// httpBeat returns the settings of the runner reading the directory of the
+// stand-in server
+func httpBeat(t *testing.T, server *httptest.Server, connectType string) *Ftpbeat {
+	u, err := parseHTTPURL(server.URL + "/out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := newFileFilter(config.FtpbeatConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Ftpbeat{
+		connectType:    connectType,
+		dialer:         &net.Dialer{Timeout: time.Second},
+		commandTimeout: time.Second,
+		httpURL:        u,
+		httpAuth:       authBasic,
+		username:       "ftpbeat",
+		password:       "s3cret",
+		files:          []string{"*"},
+		filter:         filter,
+		limiter:        &tokenBucket{},
+		client:         &testClient{},
+	}
+}
+
+// authorized answers 401 to the requests without the credentials of httpBeat
+func authorized(w http.ResponseWriter, r *http.Request) bool {
+	if username, password, ok := r.BasicAuth(); !ok || username != "ftpbeat" || password != "s3cret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+const indexPage = `<html><body><h1>Index of /out</h1>
+<a href="?C=N;O=D">Name</a>
+<a href="../">Parent Directory</a>
+<a href="sub/">sub/</a>
+<a href="a.csv">a.csv</a>
+<a href="/out/b.csv">b.csv</a>
+<a href="a.csv">a.csv again</a>
+<a href="c%20d.csv">c d.csv</a>
+<a href="/other/x.csv">elsewhere</a>
+<a href="http://mirror.example.com/out/y.csv">mirror</a>
+<a href="sub/z.csv">in a sub directory</a>
+</body></html>`
+
+func TestHTTPIndex(t *testing.T) {
+	modified := time.Date(2026, time.October, 18, 6, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+		switch r.URL.Path {
+		case "/out/":
+			fmt.Fprint(w, indexPage)
+		case "/out/b.csv":
+			w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+			fmt.Fprint(w, "1,2\n3,4\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	bt := httpBeat(t, server, ctHTTPIndex)
+
+	f := &stHTTP{}
+	if err := f.Init(bt); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Login(bt); err != nil {
+		t.Fatal(err)
+	}
+	files, err := f.CheckFiles(bt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(fileNames(files), "|"); got != "a.csv|b.csv|c d.csv" {
+		t.Errorf("listed %q, want the files linked in the directory", got)
+	}
+
+	// The index has no sizes nor times, the events take them from the response
+	if err := f.GenEvent(remoteFile{Name: "b.csv"}, bt, nil); err != nil {
+		t.Fatal(err)
+	}
+	client := bt.client.(*testClient)
+	if got := strings.Join(client.messages(), " "); got != "1,2 3,4" {
+		t.Errorf("published %q", got)
+	}
+	fields := client.events[0]["file"].(common.MapStr)
+	if fields["size"] != int64(8) {
+		t.Errorf("size %v, want 8", fields["size"])
+	}
+	if mtime, _ := fields["mtime"].(common.Time); !time.Time(mtime).Equal(modified) {
+		t.Errorf("mtime %v, want %v", fields["mtime"], modified)
+	}
+
+	bt.password = "wrong"
+	if err := f.Login(bt); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Login() = %v with a wrong password", err)
+	}
+}
+
+const propfindResponse = `<?xml version="1.0" encoding="utf-8"?>
+<D:multistatus xmlns:D="DAV:">
+<D:response><D:href>/out/</D:href>
+<D:propstat><D:prop><D:resourcetype><D:collection/></D:resourcetype></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat>
+</D:response>
+<D:response><D:href>/out/a.csv</D:href>
+<D:propstat><D:prop><D:resourcetype/><D:getcontentlength>8</D:getcontentlength><D:getlastmodified>Sun, 18 Oct 2026 06:00:00 GMT</D:getlastmodified></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat>
+</D:response>
+<D:response><D:href>http://HOST/out/b%20c.csv</D:href>
+<D:propstat><D:prop><D:resourcetype/></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat>
+<D:propstat><D:prop><D:getcontentlength/></D:prop><D:status>HTTP/1.1 404 Not Found</D:status></D:propstat>
+</D:response>
+<D:response><D:href>/out/sub/</D:href>
+<D:propstat><D:prop><D:resourcetype><D:collection/></D:resourcetype></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat>
+</D:response>
+</D:multistatus>`
+
+func TestWebDAVPropfind(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+		if r.Method != "PROPFIND" || r.URL.Path != "/out/" {
+			http.Error(w, "unexpected "+r.Method, http.StatusMethodNotAllowed)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("Depth") == "1" && !strings.Contains(string(body), "getcontentlength") {
+			http.Error(w, "no properties asked", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(207)
+		fmt.Fprint(w, strings.Replace(propfindResponse, "HOST", server.Listener.Addr().String(), 1))
+	}))
+	defer server.Close()
+	bt := httpBeat(t, server, ctWebDAV)
+
+	f := &stHTTP{}
+	if err := f.Init(bt); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Login(bt); err != nil {
+		t.Fatal(err)
+	}
+	files, err := f.CheckFiles(bt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("listed %v, want the files of the collection", fileNames(files))
+	}
+	a, bc := files[0], files[1]
+	if a.Name != "a.csv" || a.Size != 8 || !a.SizeKnown || !a.ModTime.Equal(time.Date(2026, time.October, 18, 6, 0, 0, 0, time.UTC)) {
+		t.Errorf("a.csv listed as %+v", a)
+	}
+	if bc.Name != "b c.csv" || bc.SizeKnown || !bc.ModTime.IsZero() {
+		t.Errorf("b c.csv listed as %+v", bc)
+	}
+}
+
+func TestWebDAVInvalidResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(207)
+		fmt.Fprint(w, "<html>not a multistatus")
+	}))
+	defer server.Close()
+	bt := httpBeat(t, server, ctWebDAV)
+
+	f := &stHTTP{}
+	f.Init(bt)
+	if _, err := f.CheckFiles(bt); err == nil || !strings.Contains(err.Error(), "invalid response") {
+		t.Errorf("CheckFiles() = %v", err)
+	}
+}
+
+func TestChildName(t *testing.T) {
+	dir, _ := url.Parse("https://files.example.com/out/")
+	tests := []struct {
+		href string
+		name string
+	}{
+		{"a.csv", "a.csv"},
+		{"./a.csv", "a.csv"},
+		{"/out/a.csv", "a.csv"},
+		{"https://files.example.com/out/a.csv", "a.csv"},
+		{"a%20b.csv", "a b.csv"},
+		{"", ""},
+		{"/out/", ""},
+		{"../a.csv", ""},
+		{"sub/", ""},
+		{"sub/a.csv", ""},
+		{"/other/a.csv", ""},
+		{"/outside.csv", ""},
+		{"https://mirror.example.com/out/a.csv", ""},
+		{"?C=M;O=A", ""},
+		{"a.csv?download=1", ""},
+		{"%zz", ""},
+	}
+	for _, test := range tests {
+		name, ok := childName(dir, test.href)
+		if name != test.name || ok != (test.name != "") {
+			t.Errorf("childName(%q) = %q, %v, want %q", test.href, name, ok, test.name)
+		}
+	}
+}
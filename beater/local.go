@@ -0,0 +1,199 @@
+package beater
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// stLocal reads files from a directory of the local filesystem, e.g. an NFS
+// mount files are dropped onto. The remote directory is a local path.
+type stLocal struct {
+	// root is the part of the remote directory before any date placeholder,
+	// which must always be there
+	root string
+}
+
+// Init checks the directory is there, a missing mount fails like an
+// unreachable server
+func (f *stLocal) Init(bt *Ftpbeat) error {
+	f.root = expandHome(bt.remoteRoot)
+	info, err := os.Stat(f.root)
+	if err != nil {
+		logp.Err("%v", err)
+		return err
+	}
+	if !info.IsDir() {
+		err = fmt.Errorf("%s is not a directory", f.root)
+		logp.Err("%v", err)
+		return err
+	}
+	return nil
+}
+
+// Login does nothing, there is no server to log into
+func (f *stLocal) Login(bt *Ftpbeat) error {
+	return nil
+}
+
+func (f *stLocal) CheckFiles(bt *Ftpbeat) ([]remoteFile, error) {
+	infos, err := ioutil.ReadDir(f.dir(bt))
+	if err != nil {
+		logp.Err("%v", err)
+		return nil, err
+	}
+
+	var listing []remoteFile
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		listing = append(listing, remoteFile{Name: info.Name(), Size: info.Size(), SizeKnown: true, ModTime: info.ModTime(), Mode: info.Mode()})
+	}
+
+	files := bt.selectRemote(listing)
+	logp.Info("Files : %v", fileNames(files))
+	return files, nil
+}
+
+// ListTree walks the directory and its sub directories
+func (f *stLocal) ListTree(bt *Ftpbeat) ([]remoteFile, error) {
+	var files []remoteFile
+	err := filepath.Walk(f.dir(bt), func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(f.dir(bt), p)
+		if err != nil {
+			return err
+		}
+		files = append(files, remoteFile{Name: filepath.ToSlash(rel), Size: info.Size(), SizeKnown: true, ModTime: info.ModTime(), Mode: info.Mode()})
+		return nil
+	})
+	if err != nil {
+		logp.Err("%v", err)
+		return nil, err
+	}
+	return files, nil
+}
+
+// Session returns a runner of its own, files are opened per transfer
+func (f *stLocal) Session(bt *Ftpbeat) (integratedFunc, error) {
+	return &stLocal{root: f.root}, nil
+}
+
+// Reconnect checks the directory again
+func (f *stLocal) Reconnect(bt *Ftpbeat) error {
+	return f.Init(bt)
+}
+
+// KeepAlive checks the directory is still there, a stale mount fails here
+func (f *stLocal) KeepAlive() error {
+	_, err := os.Stat(f.root)
+	return err
+}
+
+func (f *stLocal) GenEventForLocalFile(file remoteFile, bt *Ftpbeat, b *beat.Beat) error {
+	return genEventForLocalFile(file, bt)
+}
+
+func (f *stLocal) GenEvent(file remoteFile, bt *Ftpbeat, b *beat.Beat) error {
+	r, err := os.Open(filepath.Join(f.dir(bt), file.Name))
+	if err != nil {
+		logp.Err("%v", err)
+		return err
+	}
+	err = publishLines(bt, file, bt.throttle(r))
+	r.Close()
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+	}
+	return err
+}
+
+func (f *stLocal) CopyFiles(file remoteFile, bt *Ftpbeat) error {
+	src := filepath.Join(f.dir(bt), file.Name)
+	dst := bt.localPath(file.Name)
+
+	// Copying a file onto itself would truncate it
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+		return err
+	}
+	if dstInfo, err := os.Stat(dst); err == nil && os.SameFile(srcInfo, dstInfo) {
+		return nil
+	}
+
+	r, err := os.Open(src)
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+		return err
+	}
+	defer r.Close()
+
+	err = bt.saveFile(file.Name, bt.throttle(r))
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+	}
+	return err
+}
+
+// Open returns the contents of the file
+func (f *stLocal) Open(file remoteFile, bt *Ftpbeat) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(f.dir(bt), file.Name))
+}
+
+// CheckRemoteDirectory checks the directory is still there
+func (f *stLocal) CheckRemoteDirectory(bt *Ftpbeat) error {
+	_, err := os.Stat(f.dir(bt))
+	if err != nil {
+		logp.Err("%v", err)
+	}
+	return err
+}
+
+func (f *stLocal) PutFile(file remoteFile, bt *Ftpbeat) error {
+	r, err := os.Open(filepath.Join(bt.currentDirectory, file.Name))
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+		return err
+	}
+	defer r.Close()
+
+	tmp := filepath.Join(f.dir(bt), bt.tempName(file.Name))
+	outf, err := os.Create(tmp)
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+		return err
+	}
+	_, err = io.Copy(outf, bt.throttle(r))
+	if cerr := outf.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(f.dir(bt), file.Name))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		logp.Err("%v : %s", err, file.Name)
+	}
+	return err
+}
+
+// dir returns the directory of the current period
+func (f *stLocal) dir(bt *Ftpbeat) string {
+	return expandHome(bt.remoteDirectory)
+}
+
+// Quit does nothing, no file is kept open between transfers
+func (f *stLocal) Quit() {
+}
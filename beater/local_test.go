@@ -0,0 +1,178 @@
+package beater
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/affinity226/ftpbeat/config"
+)
+
+// localBeat returns the settings of the local runner reading the remote
+// directory into the current one, both temporary
+func localBeat(t *testing.T, files ...string) *Ftpbeat {
+	filter, err := newFileFilter(config.FtpbeatConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := t.TempDir()
+	return &Ftpbeat{
+		connectType:      ctLocal,
+		remoteRoot:       remote,
+		remoteDirectory:  remote,
+		currentDirectory: t.TempDir(),
+		files:            files,
+		filter:           filter,
+		limiter:          &tokenBucket{},
+		client:           &testClient{},
+		putTempSuffix:    ".part",
+	}
+}
+
+func writeFile(t *testing.T, name, contents string) {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, name string) string {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestLocalInit(t *testing.T) {
+	bt := localBeat(t)
+	if err := new(stLocal).Init(bt); err != nil {
+		t.Errorf("Init() = %v", err)
+	}
+
+	bt.remoteRoot = filepath.Join(bt.remoteDirectory, "missing")
+	if err := new(stLocal).Init(bt); err == nil {
+		t.Errorf("missing directory accepted")
+	}
+
+	bt.remoteRoot = filepath.Join(bt.remoteDirectory, "file")
+	writeFile(t, bt.remoteRoot, "")
+	if err := new(stLocal).Init(bt); err == nil || !strings.Contains(err.Error(), "is not a directory") {
+		t.Errorf("Init() = %v on a file", err)
+	}
+}
+
+func TestLocalList(t *testing.T) {
+	bt := localBeat(t, "*.csv")
+	writeFile(t, filepath.Join(bt.remoteDirectory, "a.csv"), "1\n")
+	writeFile(t, filepath.Join(bt.remoteDirectory, "b.csv"), "22\n")
+	writeFile(t, filepath.Join(bt.remoteDirectory, "c.txt"), "")
+	writeFile(t, filepath.Join(bt.remoteDirectory, "sub", "d.csv"), "4444\n")
+
+	f := &stLocal{}
+	if err := f.Init(bt); err != nil {
+		t.Fatal(err)
+	}
+	files, err := f.CheckFiles(bt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(fileNames(files), " "); got != "a.csv b.csv" {
+		t.Errorf("CheckFiles() = %q, want the csv files of the directory", got)
+	}
+	if files[1].Size != 3 || !files[1].SizeKnown || files[1].ModTime.IsZero() {
+		t.Errorf("b.csv listed as %+v", files[1])
+	}
+
+	tree, err := f.ListTree(bt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(fileNames(tree), " "); got != "a.csv b.csv c.txt sub/d.csv" {
+		t.Errorf("ListTree() = %q", got)
+	}
+
+	os.RemoveAll(bt.remoteDirectory)
+	if err := f.KeepAlive(); err == nil {
+		t.Errorf("KeepAlive() succeeded on a removed directory")
+	}
+}
+
+func TestLocalRead(t *testing.T) {
+	bt := localBeat(t)
+	writeFile(t, filepath.Join(bt.remoteDirectory, "a.log"), "l1\nl2\n")
+
+	if err := new(stLocal).GenEvent(remoteFile{Name: "a.log"}, bt, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(bt.client.(*testClient).messages(), " "); got != "l1 l2" {
+		t.Errorf("published %q", got)
+	}
+	if err := new(stLocal).GenEvent(remoteFile{Name: "missing.log"}, bt, nil); err == nil {
+		t.Errorf("missing file read")
+	}
+}
+
+func TestLocalGet(t *testing.T) {
+	bt := localBeat(t)
+	writeFile(t, filepath.Join(bt.remoteDirectory, "a.log"), "l1\nl2\n")
+
+	f := &stLocal{}
+	if err := f.CopyFiles(remoteFile{Name: "a.log"}, bt); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(bt.currentDirectory, "a.log")); got != "l1\nl2\n" {
+		t.Errorf("copied %q", got)
+	}
+
+	// Getting a file into its own directory leaves it alone
+	bt.currentDirectory = bt.remoteDirectory
+	if err := f.CopyFiles(remoteFile{Name: "a.log"}, bt); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(bt.remoteDirectory, "a.log")); got != "l1\nl2\n" {
+		t.Errorf("file copied onto itself is now %q", got)
+	}
+}
+
+func TestLocalPut(t *testing.T) {
+	for _, after := range []string{afterMove, afterDelete} {
+		bt := localBeat(t)
+		bt.putAfter = after
+		bt.putMoveTo = filepath.Join(t.TempDir(), "sent")
+		writeFile(t, filepath.Join(bt.currentDirectory, "a.csv"), "1,2\n")
+		writeFile(t, filepath.Join(bt.remoteDirectory, "a.csv"), "old\n")
+
+		f := &stLocal{}
+		if err := f.CheckRemoteDirectory(bt); err != nil {
+			t.Fatal(err)
+		}
+		if err := bt.putFile(f, remoteFile{Name: "a.csv"}); err != nil {
+			t.Fatalf("%s: %v", after, err)
+		}
+		if got := readFile(t, filepath.Join(bt.remoteDirectory, "a.csv")); got != "1,2\n" {
+			t.Errorf("%s: uploaded %q", after, got)
+		}
+		if _, err := os.Stat(filepath.Join(bt.remoteDirectory, "a.csv.part")); !os.IsNotExist(err) {
+			t.Errorf("%s: temporary file left: %v", after, err)
+		}
+
+		if _, err := os.Stat(filepath.Join(bt.currentDirectory, "a.csv")); !os.IsNotExist(err) {
+			t.Errorf("%s: local file left after the upload: %v", after, err)
+		}
+		_, err := os.Stat(filepath.Join(bt.putMoveTo, "a.csv"))
+		if moved := err == nil; moved != (after == afterMove) {
+			t.Errorf("%s: local file moved %v", after, moved)
+		}
+	}
+
+	bt := localBeat(t)
+	os.RemoveAll(bt.remoteDirectory)
+	if err := new(stLocal).CheckRemoteDirectory(bt); err == nil {
+		t.Errorf("missing remote directory accepted")
+	}
+}
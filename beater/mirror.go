@@ -0,0 +1,219 @@
+package beater
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// treeLister is implemented by the runners that can list the remote tree,
+// for the mirror execute type
+type treeLister interface {
+	// ListTree returns the files of the remote directory and of all its sub
+	// directories, named by their slash separated path relative to it
+	ListTree(bt *Ftpbeat) ([]remoteFile, error)
+}
+
+// matchTree selects the files of the tree whose name or path matches one of
+// the configured patterns
+func matchTree(patterns []string, listing []remoteFile, fold bool) []remoteFile {
+	var matched []remoteFile
+	for _, file := range listing {
+		for _, pattern := range patterns {
+			if globMatch(pattern, file.Name, fold) || globMatch(pattern, path.Base(file.Name), fold) {
+				matched = append(matched, file)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// mirror makes the current directory a copy of the remote tree: new and
+// changed files are downloaded and, if enabled, files gone from the remote
+// tree are deleted. A summary event tells what was done.
+func (bt *Ftpbeat) mirror(b *beat.Beat) error {
+	files, err := bt.runner.(treeLister).ListTree(bt)
+	if err != nil {
+		return err
+	}
+	bt.listed = append(bt.listed, files...)
+	files = bt.selectTree(files)
+	added, updated, unchanged := bt.compareTree(files)
+
+	// The files beyond the limit per run are pending, left for the next runs
+	queued := append(append([]remoteFile(nil), added...), updated...)
+	bt.orderFiles(queued)
+	queued = bt.limitFiles(queued)
+	transferred := make(map[string]bool)
+	for _, file := range queued {
+		transferred[file.Name] = true
+	}
+	for _, name := range bt.transfer(queued, b) {
+		delete(transferred, name)
+	}
+
+	summary := common.MapStr{
+		"unchanged": len(unchanged),
+		"failed":    len(queued) - len(transferred),
+		"pending":   len(added) + len(updated) - len(queued),
+	}
+	var bytes int64
+	for key, list := range map[string][]remoteFile{"added": added, "updated": updated} {
+		count := 0
+		for _, file := range list {
+			if transferred[file.Name] {
+				count++
+				bytes += file.Size
+			}
+		}
+		summary[key] = count
+	}
+	summary["bytes"] = bytes
+
+	deleted := 0
+	if bt.mayDeleteLocalFiles(files) {
+		deleted = bt.deleteLocalFiles(files)
+	}
+	summary["deleted"] = deleted
+
+	logp.Info("Mirror : %v", summary)
+	bt.client.PublishEvent(common.MapStr{
+		"@timestamp": common.Time(time.Now()),
+		"type":       bt.connectType,
+		"message":    fmt.Sprintf("Mirrored %s into %s", bt.remoteDirectory, bt.currentDirectory),
+		"mirror":     summary,
+	})
+	if failed := len(queued) - len(transferred); failed > 0 {
+		logp.Warn("%d of %d files failed", failed, len(queued))
+	}
+	return nil
+}
+
+// compareTree sorts the files of the remote tree into those missing from the
+// local one, those changed and those up to date. A file is up to date when it
+// has the size and time of the remote one, the time being set on the local
+// copy once downloaded.
+func (bt *Ftpbeat) compareTree(files []remoteFile) (added, updated, unchanged []remoteFile) {
+	for _, file := range files {
+		info, err := os.Stat(bt.localPath(file.Name))
+		switch {
+		case err != nil:
+			added = append(added, file)
+		case info.Size() != file.Size ||
+			(!file.ModTime.IsZero() && !info.ModTime().Truncate(time.Second).Equal(file.ModTime.Truncate(time.Second))):
+			updated = append(updated, file)
+		default:
+			unchanged = append(unchanged, file)
+		}
+	}
+	return added, updated, unchanged
+}
+
+// mirrorFile downloads a file of the remote tree into the same place of the
+// local one, with the time of the remote file
+func (bt *Ftpbeat) mirrorFile(runner integratedFunc, file remoteFile) error {
+	local := bt.localPath(file.Name)
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		logp.Err("%v : %s", err, file.Name)
+		return err
+	}
+	if err := runner.CopyFiles(file, bt); err != nil {
+		return err
+	}
+	if !file.ModTime.IsZero() {
+		if err := os.Chtimes(local, file.ModTime, file.ModTime); err != nil {
+			logp.Err("%v : %s", err, file.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// checkMirrorDirectory refuses to delete files from the working directory or
+// the root, where a mistyped configuration would wipe unrelated files
+func checkMirrorDirectory(dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if abs == wd || abs == filepath.Dir(abs) {
+		return fmt.Errorf("Mirror delete cannot be used with [%s] as current directory, set one for the copy only", dir)
+	}
+	return nil
+}
+
+// mayDeleteLocalFiles tells whether the local files gone from the remote tree
+// are to be deleted. An empty remote tree is more likely a server or a
+// configuration issue than all the files being removed, nothing is deleted
+// then unless delete_on_empty_listing is set.
+func (bt *Ftpbeat) mayDeleteLocalFiles(files []remoteFile) bool {
+	if !bt.mirrorDelete {
+		return false
+	}
+	if len(files) == 0 && !bt.deleteOnEmpty {
+		logp.Warn("No remote files in %s, the local files are not deleted", bt.remoteDirectory)
+		return false
+	}
+	return true
+}
+
+// deleteLocalFiles deletes the local files selected like the remote ones that
+// are no longer in the remote tree, and returns how many were deleted
+func (bt *Ftpbeat) deleteLocalFiles(files []remoteFile) int {
+	deleted := 0
+	for _, file := range bt.staleLocalFiles(files) {
+		if err := os.Remove(bt.localPath(file.Name)); err != nil {
+			logp.Err("%v : %s", err, file.Name)
+			continue
+		}
+		logp.Info("Deleted %s, gone from the remote directory", file.Name)
+		deleted++
+	}
+	return deleted
+}
+
+// staleLocalFiles returns the local files selected like the remote ones that
+// are no longer in the remote tree
+func (bt *Ftpbeat) staleLocalFiles(files []remoteFile) []remoteFile {
+	remote := make(map[string]bool)
+	for _, file := range files {
+		remote[file.Name] = true
+	}
+
+	var local []remoteFile
+	filepath.Walk(bt.currentDirectory, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() || strings.HasSuffix(p, downloadSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(bt.currentDirectory, p)
+		if err == nil {
+			local = append(local, remoteFile{Name: filepath.ToSlash(rel), Size: info.Size(), SizeKnown: true})
+		}
+		return nil
+	})
+
+	var stale []remoteFile
+	for _, file := range bt.selectTree(local) {
+		if !remote[file.Name] {
+			stale = append(stale, file)
+		}
+	}
+	return stale
+}
+
+// localPath is where a file of the remote directory goes in the current one
+func (bt *Ftpbeat) localPath(name string) string {
+	return filepath.Join(bt.currentDirectory, filepath.FromSlash(name))
+}
@@ -0,0 +1,144 @@
+package beater
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCheckMirrorDirectory(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		dir   string
+		fails bool
+	}{
+		{"./", true},
+		{".", true},
+		{wd, true},
+		{"sub/..", true},
+		{"/", true},
+		{"mirror", false},
+		{filepath.Join(os.TempDir(), "mirror"), false},
+	}
+	for _, test := range tests {
+		if err := checkMirrorDirectory(test.dir); (err != nil) != test.fails {
+			t.Errorf("checkMirrorDirectory(%q) = %v", test.dir, err)
+		}
+	}
+}
+
+func TestMirrorDeleteOnEmptyListing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a.log", "b.log"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("x\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name          string
+		delete        bool
+		deleteOnEmpty bool
+		remote        []remoteFile
+		want          int
+	}{
+		{"delete off", false, false, []remoteFile{{Name: "a.log"}}, 0},
+		{"file gone", true, false, []remoteFile{{Name: "a.log"}}, 1},
+		{"empty listing", true, false, nil, 0},
+		{"empty listing opted in", true, true, nil, 2},
+	}
+	for _, test := range tests {
+		bt := &Ftpbeat{
+			files:            []string{"*.log"},
+			filter:           &fileFilter{},
+			currentDirectory: dir,
+			mirrorDelete:     test.delete,
+			deleteOnEmpty:    test.deleteOnEmpty,
+		}
+		deleted := 0
+		if bt.mayDeleteLocalFiles(test.remote) {
+			deleted = len(bt.staleLocalFiles(test.remote))
+		}
+		if deleted != test.want {
+			t.Errorf("%s: %d files to delete, want %d", test.name, deleted, test.want)
+		}
+	}
+}
+
+// A broken download leaves the local copy alone until the file is resumed
+// and complete
+func TestMirrorFileKeepsLocalCopy(t *testing.T) {
+	modified := time.Date(2026, time.October, 18, 6, 0, 0, 0, time.UTC)
+	var mutex sync.Mutex
+	gets := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		gets++
+		first := gets == 1
+		mutex.Unlock()
+		w.Header().Set("ETag", `"v2"`)
+		if first {
+			// The connection breaks after half the file
+			w.Header().Set("Content-Length", "8")
+			fmt.Fprint(w, "new,")
+			return
+		}
+		if r.Header.Get("Range") != "bytes=4-" || r.Header.Get("If-Range") != `"v2"` {
+			http.Error(w, "not resumed", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusPartialContent)
+		fmt.Fprint(w, "new\n")
+	}))
+	defer server.Close()
+	bt := httpBeat(t, server, ctHTTPIndex)
+	bt.currentDirectory = t.TempDir()
+	local := filepath.Join(bt.currentDirectory, "sub", "a.csv")
+	writeFile(t, local, "old\n")
+
+	f := &stHTTP{}
+	if err := f.Init(bt); err != nil {
+		t.Fatal(err)
+	}
+	file := remoteFile{Name: "sub/a.csv", Size: 8, SizeKnown: true, ModTime: modified}
+	if err := bt.mirrorFile(f, file); err == nil {
+		t.Fatalf("broken download succeeded")
+	}
+	if got := readFile(t, local); got != "old\n" {
+		t.Errorf("local copy is %q after a broken download", got)
+	}
+	if got := readFile(t, local+downloadSuffix); got != "new," {
+		t.Errorf("partial download %q", got)
+	}
+	if stale := bt.staleLocalFiles([]remoteFile{file}); len(stale) != 0 {
+		t.Errorf("partial download taken for a stale file: %v", fileNames(stale))
+	}
+
+	if err := bt.mirrorFile(f, file); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, local); got != "new,new\n" {
+		t.Errorf("local copy is %q once resumed", got)
+	}
+	if _, err := os.Stat(local + downloadSuffix); !os.IsNotExist(err) {
+		t.Errorf("partial download left: %v", err)
+	}
+	if info, err := os.Stat(local); err != nil {
+		t.Error(err)
+	} else if !info.ModTime().Equal(modified) {
+		t.Errorf("local copy time %v, want %v", info.ModTime(), modified)
+	}
+}
@@ -0,0 +1,84 @@
+package beater
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/publisher"
+)
+
+var once = flag.Bool("once", false, "Run a single pass, wait for its events to be published and exit")
+
+// ackClient publishes the events asking the outputs to signal them, so that
+// a single pass can wait for all its events before exiting
+type ackClient struct {
+	publisher.Client
+	pending sync.WaitGroup
+	failed  int64
+}
+
+// ackSignal settles an event once, whether the outputs signal it or it is
+// dropped before reaching them
+type ackSignal struct {
+	client *ackClient
+	done   sync.Once
+}
+
+func (c *ackClient) PublishEvent(event common.MapStr, opts ...publisher.ClientOption) bool {
+	c.pending.Add(1)
+	s := &ackSignal{client: c}
+	opts = append(opts, publisher.Signal(s))
+	if !c.Client.PublishEvent(event, opts...) {
+		s.settle(false)
+		return false
+	}
+	return true
+}
+
+func (c *ackClient) PublishEvents(events []common.MapStr, opts ...publisher.ClientOption) bool {
+	for _, event := range events {
+		c.PublishEvent(event, opts...)
+	}
+	return true
+}
+
+func (s *ackSignal) Completed() { s.settle(true) }
+func (s *ackSignal) Failed()    { s.settle(false) }
+func (s *ackSignal) Canceled()  { s.settle(false) }
+
+func (s *ackSignal) settle(ok bool) {
+	s.done.Do(func() {
+		if !ok {
+			atomic.AddInt64(&s.client.failed, 1)
+		}
+		s.client.pending.Done()
+	})
+}
+
+// singlePass runs a single pass and waits for its events to be acknowledged.
+// It fails when the pass, a file or an event failed, for the process to exit
+// with an error.
+func (bt *Ftpbeat) singlePass(b *beat.Beat) error {
+	client := &ackClient{Client: bt.client}
+	bt.client = client
+
+	err := bt.beat(b)
+	logp.Info("Waiting for the events to be published")
+	client.pending.Wait()
+	if err != nil {
+		return err
+	}
+	if bt.failedFiles > 0 {
+		return fmt.Errorf("%d files failed", bt.failedFiles)
+	}
+	if client.failed > 0 {
+		return fmt.Errorf("%d events could not be published", client.failed)
+	}
+	logp.Info("Single pass done")
+	return nil
+}
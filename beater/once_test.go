@@ -0,0 +1,120 @@
+package beater
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/common/op"
+	"github.com/elastic/beats/libbeat/publisher"
+)
+
+// signalingClient signals the events it publishes once the outputs would
+// have, some time later, failing the event of the fail message and dropping
+// the one of the drop message
+type signalingClient struct {
+	testClient
+	delay      time.Duration
+	fail, drop string
+	// signaled counts the events signaled
+	signaled int64
+}
+
+func (c *signalingClient) PublishEvent(event common.MapStr, opts ...publisher.ClientOption) bool {
+	if event["message"] == c.drop {
+		return false
+	}
+	c.testClient.PublishEvent(event)
+	signal := publisher.MakeContext(opts).Signal
+	go func() {
+		time.Sleep(c.delay)
+		atomic.AddInt64(&c.signaled, 1)
+		if event["message"] == c.fail {
+			op.SigFailed(signal, fmt.Errorf("rejected by the output"))
+		} else {
+			op.SigCompleted(signal)
+		}
+	}()
+	return true
+}
+
+func (c *signalingClient) PublishEvents(events []common.MapStr, opts ...publisher.ClientOption) bool {
+	for _, event := range events {
+		c.PublishEvent(event, opts...)
+	}
+	return true
+}
+
+// badFileRunner reads the local files but fails those named bad
+type badFileRunner struct {
+	*stLocal
+}
+
+func (r badFileRunner) GenEvent(file remoteFile, bt *Ftpbeat, b *beat.Beat) error {
+	if strings.HasPrefix(file.Name, "bad") {
+		return fmt.Errorf("%s: permission denied", file.Name)
+	}
+	return r.stLocal.GenEvent(file, bt, b)
+}
+
+func TestSinglePass(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      []string
+		fail, drop string
+		err        string
+	}{
+		{"published", []string{"a.log", "b.log"}, "", "", ""},
+		{"failed file", []string{"a.log", "bad.log"}, "", "", "1 files failed"},
+		{"failed event", []string{"a.log", "b.log"}, "b.log:2", "", "1 events could not be published"},
+		{"dropped event", []string{"a.log", "b.log"}, "", "a.log:1", "1 events could not be published"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bt := localBeat(t, "*.log")
+			bt.executeType = etRead
+			bt.directoryTemplate, bt.fileTemplates = bt.remoteDirectory, bt.files
+			for _, name := range test.files {
+				writeFile(t, filepath.Join(bt.remoteDirectory, name), name+":1\n"+name+":2\n")
+			}
+			client := &signalingClient{delay: 50 * time.Millisecond, fail: test.fail, drop: test.drop}
+			bt.client = client
+			bt.runner = badFileRunner{&stLocal{}}
+
+			err := bt.singlePass(nil)
+			if test.err == "" && err != nil || test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Errorf("singlePass() = %v, want %q", err, test.err)
+			}
+
+			// Every event published was signaled before returning
+			client.mutex.Lock()
+			published := len(client.events)
+			client.mutex.Unlock()
+			if published == 0 {
+				t.Fatalf("nothing published")
+			}
+			if signaled := atomic.LoadInt64(&client.signaled); signaled != int64(published) {
+				t.Errorf("returned with %d of %d events signaled", signaled, published)
+			}
+		})
+	}
+}
+
+// A pass that cannot list the directory fails the run
+func TestSinglePassFailed(t *testing.T) {
+	bt := localBeat(t, "*.log")
+	bt.executeType = etRead
+	bt.remoteRoot = filepath.Join(bt.remoteDirectory, "missing")
+	bt.directoryTemplate, bt.fileTemplates = bt.remoteDirectory, bt.files
+	bt.client = &signalingClient{}
+	bt.runner = &stLocal{}
+
+	if err := bt.singlePass(nil); err == nil {
+		t.Errorf("singlePass() succeeded with a missing directory")
+	}
+}
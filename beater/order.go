@@ -0,0 +1,107 @@
+package beater
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// supported orders of the files
+const (
+	orderByName  = "name"
+	orderByMTime = "mtime"
+	orderBySize  = "size"
+
+	orderAsc  = "asc"
+	orderDesc = "desc"
+)
+
+// orderFiles sorts the files as configured, in place. Without an order the
+// files keep the order of the listing.
+func (bt *Ftpbeat) orderFiles(files []remoteFile) {
+	var less func(a, b remoteFile) bool
+	switch bt.orderBy {
+	case orderByName:
+		less = func(a, b remoteFile) bool { return a.Name < b.Name }
+	case orderByMTime:
+		less = func(a, b remoteFile) bool { return a.ModTime.Before(b.ModTime) }
+	case orderBySize:
+		less = func(a, b remoteFile) bool { return a.Size < b.Size }
+	default:
+		return
+	}
+	if bt.order == orderDesc {
+		asc := less
+		less = func(a, b remoteFile) bool { return asc(b, a) }
+	}
+	sort.SliceStable(files, func(i, j int) bool { return less(files[i], files[j]) })
+}
+
+// limitFiles returns the files that fit in what is left of the files allowed
+// per run, the others being left for the next periods
+func (bt *Ftpbeat) limitFiles(files []remoteFile) []remoteFile {
+	if bt.maxFilesPerRun <= 0 {
+		return files
+	}
+	if len(files) > bt.filesLeft {
+		logp.Info("Leaving %d files for the next runs", len(files)-bt.filesLeft)
+		files = files[:bt.filesLeft]
+	}
+	bt.filesLeft -= len(files)
+	return files
+}
+
+// processedKey identifies a file as transferred, a file changed since having
+// another size or time
+func processedKey(file remoteFile) string {
+	return fmt.Sprintf("%s|%d|%d", file.Name, file.Size, file.ModTime.UnixNano())
+}
+
+// pendingFiles drops the files of the remote directory already transferred
+// unchanged in an earlier period, so that with max_files_per_run the backlog
+// drains instead of the first files being transferred again every period.
+// Files gone from the listing are forgotten. Without a limit every file is
+// transferred again every period, as documented.
+func (bt *Ftpbeat) pendingFiles(files []remoteFile) []remoteFile {
+	if bt.maxFilesPerRun <= 0 {
+		return files
+	}
+	if bt.processed == nil {
+		bt.processed = make(map[string]map[string]bool)
+	}
+	done := bt.processed[bt.remoteDirectory]
+	seen := make(map[string]bool)
+	var pending []remoteFile
+	for _, file := range files {
+		key := processedKey(file)
+		if done[key] {
+			seen[key] = true
+			continue
+		}
+		pending = append(pending, file)
+	}
+	bt.processed[bt.remoteDirectory] = seen
+	if skipped := len(files) - len(pending); skipped > 0 {
+		logp.Info("Skipping %d files transferred in the previous runs", skipped)
+	}
+	return pending
+}
+
+// markProcessed remembers the files transferred, those failed being retried
+// in the next period
+func (bt *Ftpbeat) markProcessed(files []remoteFile, failed []string) {
+	if bt.maxFilesPerRun <= 0 {
+		return
+	}
+	isFailed := make(map[string]bool)
+	for _, name := range failed {
+		isFailed[name] = true
+	}
+	done := bt.processed[bt.remoteDirectory]
+	for _, file := range files {
+		if !isFailed[file.Name] {
+			done[processedKey(file)] = true
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package beater
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOrderFiles(t *testing.T) {
+	at := func(hour int) time.Time { return time.Date(2026, 10, 18, hour, 0, 0, 0, time.UTC) }
+	listing := []remoteFile{
+		{Name: "b.log", Size: 30, ModTime: at(2)},
+		{Name: "c.log", Size: 10, ModTime: at(1)},
+		{Name: "a.log", Size: 20, ModTime: at(3)},
+		{Name: "d.log", Size: 10, ModTime: at(1)},
+	}
+	tests := []struct {
+		orderBy, order string
+		want           string
+	}{
+		{"", "", "b.log c.log a.log d.log"},
+		{orderByName, orderAsc, "a.log b.log c.log d.log"},
+		{orderByName, orderDesc, "d.log c.log b.log a.log"},
+		{orderByMTime, orderAsc, "c.log d.log b.log a.log"},
+		{orderByMTime, orderDesc, "a.log b.log c.log d.log"},
+		{orderBySize, orderAsc, "c.log d.log a.log b.log"},
+		{orderBySize, orderDesc, "b.log a.log c.log d.log"},
+	}
+	for _, test := range tests {
+		files := append([]remoteFile(nil), listing...)
+		bt := &Ftpbeat{orderBy: test.orderBy, order: test.order}
+		bt.orderFiles(files)
+		if got := strings.Join(fileNames(files), " "); got != test.want {
+			t.Errorf("%s %s: %s, want %s", test.orderBy, test.order, got, test.want)
+		}
+	}
+}
+
+func TestLimitFiles(t *testing.T) {
+	files := []remoteFile{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	tests := []struct {
+		max    int
+		passes []string
+	}{
+		{0, []string{"a b c", "a b c"}},
+		{2, []string{"a b", ""}},
+		{5, []string{"a b c", "a b"}},
+	}
+	for _, test := range tests {
+		bt := &Ftpbeat{maxFilesPerRun: test.max, filesLeft: test.max}
+		// Two directories share the limit of a run
+		for i, want := range test.passes {
+			if got := strings.Join(fileNames(bt.limitFiles(files)), " "); got != want {
+				t.Errorf("max %d, directory %d: %q, want %q", test.max, i+1, got, want)
+			}
+		}
+	}
+}
+
+// With max_files_per_run the files left over are transferred in the next
+// periods, whatever their position in the order
+func TestBacklogDrains(t *testing.T) {
+	at := time.Date(2026, 10, 18, 6, 0, 0, 0, time.UTC)
+	listing := []remoteFile{
+		{Name: "e.log", Size: 5, ModTime: at},
+		{Name: "a.log", Size: 1, ModTime: at},
+		{Name: "c.log", Size: 3, ModTime: at},
+		{Name: "b.log", Size: 2, ModTime: at},
+		{Name: "d.log", Size: 4, ModTime: at},
+	}
+	changed := append([]remoteFile{{Name: "a.log", Size: 10, ModTime: at.Add(time.Hour)}}, listing[2:]...)
+	changed = append(changed, listing[0])
+	// d.log and e.log are forgotten once gone from the listing
+	gone := []remoteFile{listing[2], listing[3]}
+
+	type period struct {
+		listing []remoteFile
+		failed  []string
+		want    string
+	}
+	tests := []struct {
+		name    string
+		max     int
+		periods []period
+	}{
+		{"no limit", 0, []period{
+			{listing, nil, "a.log b.log c.log d.log e.log"},
+			{listing, nil, "a.log b.log c.log d.log e.log"},
+		}},
+		{"two per run", 2, []period{
+			{listing, nil, "a.log b.log"},
+			{listing, []string{"d.log"}, "c.log d.log"},
+			{listing, nil, "d.log e.log"},
+			{listing, nil, ""},
+			{changed, nil, "a.log"},
+			{gone, nil, ""},
+			{listing, nil, "a.log d.log"},
+		}},
+	}
+	for _, test := range tests {
+		bt := &Ftpbeat{maxFilesPerRun: test.max, orderBy: orderByName, remoteDirectory: "/in"}
+		for i, period := range test.periods {
+			bt.filesLeft = bt.maxFilesPerRun
+			pending := bt.pendingFiles(append([]remoteFile(nil), period.listing...))
+			bt.orderFiles(pending)
+			queued := bt.limitFiles(pending)
+			bt.markProcessed(queued, period.failed)
+			if got := strings.Join(fileNames(queued), " "); got != period.want {
+				t.Errorf("%s, period %d: transferred %q, want %q", test.name, i+1, got, period.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package beater
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// supported proxy schemes
+const (
+	proxySOCKS5 = "socks5"
+	proxyHTTP   = "http"
+)
+
+// newDialer returns the dialer of the connections to the server, through the
+// proxy when one is configured. The timeout covers the connection to the
+// proxy and the proxy handshake.
+func newDialer(proxyURL string, timeout time.Duration) (proxy.Dialer, error) {
+	if proxyURL == "" {
+		return &net.Dialer{Timeout: timeout}, nil
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid proxy URL [%s]: %v", proxyURL, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("Invalid proxy URL [%s]: no host", proxyURL)
+	}
+
+	direct := &timeoutDialer{timeout}
+	switch u.Scheme {
+	case proxySOCKS5:
+		dialer, err := proxy.FromURL(u, direct)
+		if err != nil {
+			return nil, err
+		}
+		return &handshakeDialer{dialer}, nil
+	case proxyHTTP:
+		return &handshakeDialer{&httpConnectDialer{u, direct}}, nil
+	default:
+		return nil, fmt.Errorf("Unknown [%s] proxy scheme, supported schemes: `socks5`, `http`", u.Scheme)
+	}
+}
+
+// timeoutDialer connects with a timeout and gives the connection a deadline
+// for the proxy handshake
+type timeoutDialer struct {
+	timeout time.Duration
+}
+
+func (d *timeoutDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := net.DialTimeout(network, addr, d.timeout)
+	if err != nil {
+		return nil, err
+	}
+	if d.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(d.timeout))
+	}
+	return conn, nil
+}
+
+// handshakeDialer clears the deadline of the handshake once the proxy has
+// connected to the server
+type handshakeDialer struct {
+	proxy.Dialer
+}
+
+func (d *handshakeDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := d.Dialer.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// httpConnectDialer goes through an HTTP proxy with the CONNECT method
+type httpConnectDialer struct {
+	proxyURL *url.URL
+	forward  proxy.Dialer
+}
+
+func (d *httpConnectDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := d.forward.Dial(network, d.proxyURL.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := d.proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: CONNECT %s: %s", d.proxyURL.Host, addr, resp.Status)
+	}
+
+	// The server may already have sent data along with the response
+	if br.Buffered() > 0 {
+		return &bufferedConn{conn, br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads what is left in the reader before the connection
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// proxyHost returns the proxy URL without the credentials, for logging
+func proxyHost(proxyURL string) string {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,93 @@
+package beater
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// supported actions on a local file once uploaded
+const (
+	afterMove   = "move"
+	afterDelete = "delete"
+)
+
+// uploader is implemented by the runners that can upload files, for the put
+// execute type. Files are uploaded under a temporary name and renamed once
+// complete, so the other side never picks up a partial file.
+type uploader interface {
+	// CheckRemoteDirectory makes sure the files can be uploaded into the
+	// remote directory
+	CheckRemoteDirectory(bt *Ftpbeat) error
+	// PutFile uploads the file of the current directory
+	PutFile(file remoteFile, bt *Ftpbeat) error
+}
+
+// localFiles lists the files of the current directory to upload
+func (bt *Ftpbeat) localFiles() ([]remoteFile, error) {
+	infos, err := ioutil.ReadDir(bt.currentDirectory)
+	if err != nil {
+		logp.Err("%v", err)
+		return nil, err
+	}
+
+	var listing []remoteFile
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		listing = append(listing, remoteFile{Name: info.Name(), Size: info.Size(), SizeKnown: true, ModTime: info.ModTime()})
+	}
+
+	files := bt.selectFiles(listing)
+	logp.Info("Files : %v", fileNames(files))
+	return files, nil
+}
+
+// putFile uploads a local file, publishes its delivery and moves or deletes
+// it so it is not uploaded again
+func (bt *Ftpbeat) putFile(runner integratedFunc, file remoteFile) error {
+	start := time.Now()
+	if err := runner.(uploader).PutFile(file, bt); err != nil {
+		return err
+	}
+	logp.Info("Uploaded %s", file.Name)
+
+	if bt.putEvent {
+		bt.client.PublishEvent(common.MapStr{
+			"@timestamp": common.Time(time.Now()),
+			"type":       bt.connectType,
+			"message":    fmt.Sprintf("Uploaded %s", file.Name),
+			"file":       file.fields(),
+			"delivery": common.MapStr{
+				"host":        bt.hostname,
+				"directory":   bt.remoteDirectory,
+				"duration_ms": time.Since(start).Nanoseconds() / int64(time.Millisecond),
+			},
+		})
+	}
+
+	local := filepath.Join(bt.currentDirectory, file.Name)
+	var err error
+	if bt.putAfter == afterDelete {
+		err = os.Remove(local)
+	} else {
+		if err = os.MkdirAll(bt.putMoveTo, 0755); err == nil {
+			err = os.Rename(local, filepath.Join(bt.putMoveTo, file.Name))
+		}
+	}
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+	}
+	return err
+}
+
+// tempName is the name a file is uploaded under before being renamed
+func (bt *Ftpbeat) tempName(name string) string {
+	return name + bt.putTempSuffix
+}
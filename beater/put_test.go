@@ -0,0 +1,132 @@
+package beater
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// putBeat returns the settings uploading the current directory to the
+// stand-in
+func putBeat(t *testing.T, standIn *ftpStandIn, after string) *Ftpbeat {
+	bt := standIn.beat()
+	bt.connectType = ctFTP
+	bt.currentDirectory = t.TempDir()
+	bt.putTempSuffix = ".part"
+	bt.putAfter = after
+	bt.putMoveTo = filepath.Join(t.TempDir(), "sent")
+	bt.limiter = &tokenBucket{}
+	bt.client = &testClient{}
+	return bt
+}
+
+func TestFTPPut(t *testing.T) {
+	tests := []struct {
+		name        string
+		after       string
+		noOverwrite bool
+	}{
+		{"move", afterMove, false},
+		{"delete", afterDelete, false},
+		{"rename refused over the old file", afterMove, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			standIn := newFTPStandIn(t, "tcp4", "127.0.0.1:0")
+			standIn.noOverwrite = test.noOverwrite
+			standIn.files["a.csv"] = "old\n"
+			bt := putBeat(t, standIn, test.after)
+			bt.putEvent = true
+			writeFile(t, filepath.Join(bt.currentDirectory, "a.csv"), "1,2\n")
+
+			f := &stFTP{}
+			if err := f.Init(bt); err != nil {
+				t.Fatal(err)
+			}
+			defer f.Quit()
+			if err := f.Login(bt); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.CheckRemoteDirectory(bt); err != nil {
+				t.Fatal(err)
+			}
+			if err := bt.putFile(f, remoteFile{Name: "a.csv", Size: 4, SizeKnown: true}); err != nil {
+				t.Fatal(err)
+			}
+
+			// Stored under the temporary name then renamed over the old file
+			standIn.mutex.Lock()
+			uploaded, temporary := standIn.files["a.csv"], standIn.files["a.csv.part"]
+			standIn.mutex.Unlock()
+			if uploaded != "1,2\n" || temporary != "" {
+				t.Errorf("uploaded %q, temporary file %q", uploaded, temporary)
+			}
+			if n := standIn.received("STOR"); n != 1 {
+				t.Errorf("%d STOR commands, want 1", n)
+			}
+			if n := standIn.received("DELE"); (n > 0) != test.noOverwrite {
+				t.Errorf("%d DELE commands", n)
+			}
+
+			client := bt.client.(*testClient)
+			if got := strings.Join(client.messages(), " "); got != "Uploaded a.csv" {
+				t.Errorf("published %q", got)
+			}
+			delivery := client.events[0]["delivery"].(common.MapStr)
+			if delivery["directory"] != "/data" || delivery["host"] != bt.hostname {
+				t.Errorf("delivery %v", delivery)
+			}
+
+			if _, err := os.Stat(filepath.Join(bt.currentDirectory, "a.csv")); !os.IsNotExist(err) {
+				t.Errorf("local file left after the upload: %v", err)
+			}
+			_, err := os.Stat(filepath.Join(bt.putMoveTo, "a.csv"))
+			if moved := err == nil; moved != (test.after == afterMove) {
+				t.Errorf("local file moved %v", moved)
+			}
+		})
+	}
+}
+
+func TestFTPPutFailed(t *testing.T) {
+	standIn := newFTPStandIn(t, "tcp4", "127.0.0.1:0")
+	bt := putBeat(t, standIn, afterDelete)
+	writeFile(t, filepath.Join(bt.currentDirectory, "a.csv"), "1,2\n")
+
+	f := &stFTP{}
+	if err := f.Init(bt); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Quit()
+	if err := f.Login(bt); err != nil {
+		t.Fatal(err)
+	}
+
+	// A missing local file is not uploaded
+	if err := bt.putFile(f, remoteFile{Name: "missing.csv"}); err == nil {
+		t.Errorf("missing local file uploaded")
+	}
+
+	// A missing remote directory fails before any upload, keeping the file
+	standIn.mutex.Lock()
+	standIn.missingDir = "/data"
+	standIn.mutex.Unlock()
+	if err := f.CheckRemoteDirectory(bt); err == nil {
+		t.Errorf("missing remote directory accepted")
+	}
+	if err := bt.putFile(f, remoteFile{Name: "a.csv"}); err == nil {
+		t.Errorf("file uploaded into a missing directory")
+	}
+	if got := readFile(t, filepath.Join(bt.currentDirectory, "a.csv")); got != "1,2\n" {
+		t.Errorf("local file is now %q after a failed upload", got)
+	}
+	if n := standIn.received("STOR"); n != 0 {
+		t.Errorf("%d STOR commands, want none", n)
+	}
+	if len(bt.client.(*testClient).events) != 0 {
+		t.Errorf("failed upload published")
+	}
+}
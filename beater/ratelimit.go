@@ -0,0 +1,170 @@
+package beater
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/affinity226/ftpbeat/config"
+)
+
+// globalLimiter caps the bandwidth of all transfers of the process
+var globalLimiter = &tokenBucket{}
+
+// tokenBucket hands out bytes at the configured rate with a burst of one
+// second worth of data. A rate of zero or less means unlimited.
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   func() int64
+	tokens float64
+	last   time.Time
+}
+
+// take consumes n bytes and returns how long the caller has to wait for the
+// transfer to stay within the rate.
+func (tb *tokenBucket) take(n int) time.Duration {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	var rate float64
+	if tb.rate != nil {
+		rate = float64(tb.rate())
+	}
+	if rate <= 0 {
+		tb.last = time.Time{}
+		return 0
+	}
+
+	now := time.Now()
+	if tb.last.IsZero() {
+		tb.tokens = rate
+	} else {
+		tb.tokens += now.Sub(tb.last).Seconds() * rate
+		if tb.tokens > rate {
+			tb.tokens = rate
+		}
+	}
+	tb.last = now
+
+	tb.tokens -= float64(n)
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / rate * float64(time.Second))
+}
+
+// limitedReader throttles reads through one or more token buckets
+type limitedReader struct {
+	io.Reader
+	buckets []*tokenBucket
+	// conn, shared with other transfers, must not time out while the reader
+	// waits as nothing is read from it then
+	conn *deadlineConn
+}
+
+// maxThrottledRead keeps single reads small so the throttling stays smooth
+const maxThrottledRead = 32 * 1024
+
+// Read reads at most one second worth of data of the lowest rate, so the
+// wait after a read stays around a second whatever the rate
+func (r *limitedReader) Read(b []byte) (int, error) {
+	size := maxThrottledRead
+	for _, bucket := range r.buckets {
+		if bucket.rate == nil {
+			continue
+		}
+		if rate := bucket.rate(); rate > 0 && rate < int64(size) {
+			size = int(rate)
+		}
+	}
+	if len(b) > size {
+		b = b[:size]
+	}
+
+	// Every bucket refills while the reader waits, so the longest wait
+	// satisfies them all
+	n, err := r.Reader.Read(b)
+	var wait time.Duration
+	for _, bucket := range r.buckets {
+		if w := bucket.take(n); w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		if r.conn != nil {
+			r.conn.end()
+		}
+		time.Sleep(wait)
+		if r.conn != nil {
+			r.conn.begin()
+		}
+	}
+	return n, err
+}
+
+// throttle wraps r so it honours the input and the global rate limits
+func (bt *Ftpbeat) throttle(r io.Reader) io.Reader {
+	return &limitedReader{Reader: r, buckets: []*tokenBucket{bt.limiter, globalLimiter}}
+}
+
+// throttleConn throttles r read from a transfer over conn, the deadline of
+// conn being lifted while waiting
+func (bt *Ftpbeat) throttleConn(r io.Reader, conn *deadlineConn) io.Reader {
+	return &limitedReader{Reader: r, buckets: []*tokenBucket{bt.limiter, globalLimiter}, conn: conn}
+}
+
+// rateWindow is a time of day range with its own rate limit
+type rateWindow struct {
+	from, to  time.Duration
+	rateLimit int64
+}
+
+// parseRateWindows parses the rate limit schedule from the config
+func parseRateWindows(schedule []config.RateLimitWindow) ([]rateWindow, error) {
+	var windows []rateWindow
+	for _, w := range schedule {
+		from, err := parseTimeOfDay(w.From)
+		if err != nil {
+			return nil, err
+		}
+		to, err := parseTimeOfDay(w.To)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, rateWindow{from, to, w.RateLimit})
+	}
+	return windows, nil
+}
+
+// parseTimeOfDay parses a "15:04" formatted time into the offset from midnight
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time of day [%s], expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// contains tells whether the time of day falls in the window, which may
+// span midnight
+func (w rateWindow) contains(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+	if w.from <= w.to {
+		return offset >= w.from && offset < w.to
+	}
+	return offset >= w.from || offset < w.to
+}
+
+// currentRateLimit returns the limit of the first scheduled window matching
+// the current time, the default limit otherwise
+func currentRateLimit(windows []rateWindow, def int64) int64 {
+	now := time.Now()
+	for _, w := range windows {
+		if w.contains(now) {
+			return w.rateLimit
+		}
+	}
+	return def
+}
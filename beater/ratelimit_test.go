@@ -0,0 +1,141 @@
+package beater
+
+import (
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fixedRate(rate int64) func() int64 {
+	return func() int64 { return rate }
+}
+
+func TestTokenBucketTake(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  int64
+		takes []int
+		want  time.Duration
+	}{
+		{"unlimited", 0, []int{1 << 20, 1 << 20}, 0},
+		{"within the burst", 1000, []int{400, 600}, 0},
+		{"over the burst", 1000, []int{1000, 500}, 500 * time.Millisecond},
+		{"far over the burst", 1000, []int{3000}, 2 * time.Second},
+	}
+	for _, test := range tests {
+		tb := &tokenBucket{rate: fixedRate(test.rate)}
+		var wait time.Duration
+		for _, n := range test.takes {
+			wait = tb.take(n)
+		}
+		// A little time goes by between the takes
+		if wait < test.want-10*time.Millisecond || wait > test.want {
+			t.Errorf("%s: take() = %v, want %v", test.name, wait, test.want)
+		}
+	}
+}
+
+func TestLimitedReaderReadSize(t *testing.T) {
+	tests := []struct {
+		rates []int64
+		want  int
+	}{
+		{[]int64{0, 0}, maxThrottledRead},
+		{[]int64{1 << 20, 0}, maxThrottledRead},
+		{[]int64{0, 1000}, 1000},
+		{[]int64{5000, 2000}, 2000},
+	}
+	for _, test := range tests {
+		var buckets []*tokenBucket
+		for _, rate := range test.rates {
+			buckets = append(buckets, &tokenBucket{rate: fixedRate(rate)})
+		}
+		r := &limitedReader{Reader: strings.NewReader(strings.Repeat("x", 1<<16)), buckets: buckets}
+		n, err := r.Read(make([]byte, 1<<16))
+		if err != nil || n != test.want {
+			t.Errorf("rates %v: Read() = %d, %v, want %d", test.rates, n, err, test.want)
+		}
+	}
+}
+
+// With both a per-connection and a global limit, the reader waits for the
+// slowest bucket rather than for each one in turn
+func TestLimitedReaderWaitsLongest(t *testing.T) {
+	r := &limitedReader{
+		Reader:  strings.NewReader(strings.Repeat("x", 5000)),
+		buckets: []*tokenBucket{{rate: fixedRate(4000)}, {rate: fixedRate(4000)}},
+	}
+	start := time.Now()
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	// 1000 bytes over the burst of 4000 bytes/s take a quarter of a second
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > 400*time.Millisecond {
+		t.Errorf("read in %v, want about 250ms", elapsed)
+	}
+}
+
+// A connection shared with other transfers, like an SSH one, has a read
+// pending while the throttled reader waits. It must not time out then.
+func TestLimitedReaderLiftsDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := newDeadlineConn(client, 100*time.Millisecond, false)
+	conn.begin()
+	defer conn.Close()
+
+	failed := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		failed <- err
+	}()
+
+	// The second read waits a quarter of a second, longer than the timeout
+	r := &limitedReader{
+		Reader:  strings.NewReader(strings.Repeat("x", 5000)),
+		buckets: []*tokenBucket{{rate: fixedRate(4000)}},
+		conn:    conn,
+	}
+	start := time.Now()
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("read in %v, the reader did not wait", elapsed)
+	}
+	select {
+	case err := <-failed:
+		t.Fatalf("connection failed while the reader waited: %v", err)
+	default:
+	}
+}
+
+func TestRateWindowContains(t *testing.T) {
+	at := func(clock string) time.Time {
+		t, _ := time.Parse("15:04", clock)
+		return t
+	}
+	tests := []struct {
+		from, to string
+		clock    string
+		want     bool
+	}{
+		{"08:00", "18:00", "08:00", true},
+		{"08:00", "18:00", "17:59", true},
+		{"08:00", "18:00", "18:00", false},
+		{"08:00", "18:00", "07:59", false},
+		{"22:00", "06:00", "23:30", true},
+		{"22:00", "06:00", "05:59", true},
+		{"22:00", "06:00", "12:00", false},
+	}
+	for _, test := range tests {
+		from, _ := parseTimeOfDay(test.from)
+		to, _ := parseTimeOfDay(test.to)
+		w := rateWindow{from: from, to: to}
+		if got := w.contains(at(test.clock)); got != test.want {
+			t.Errorf("%s-%s contains %s = %v, want %v", test.from, test.to, test.clock, got, test.want)
+		}
+	}
+}
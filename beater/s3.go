@@ -0,0 +1,162 @@
+package beater
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// stS3 reads the objects directly under the prefix of a bucket, their keys
+// relative to the prefix being the file names
+type stS3 struct {
+	client *s3Client
+
+	// partials holds the copies aborted part way, resumed with a ranged GET
+	// when the transfer is retried
+	partials map[string]partialCopy
+}
+
+func (f *stS3) Init(bt *Ftpbeat) error {
+	f.client = &s3Client{
+		http:            newHTTPClient(bt),
+		endpoint:        bt.s3Endpoint,
+		bucket:          bt.s3.Bucket,
+		region:          bt.s3.Region,
+		accessKeyID:     bt.s3.AccessKeyID,
+		secretAccessKey: bt.s3.SecretAccessKey,
+		sessionToken:    bt.s3.SessionToken,
+		pathStyle:       bt.s3.PathStyle,
+	}
+	return nil
+}
+
+// Login checks the bucket can be accessed with the credentials
+func (f *stS3) Login(bt *Ftpbeat) error {
+	err := f.client.headBucket()
+	if err != nil {
+		logp.Err("%v", err)
+	}
+	return err
+}
+
+func (f *stS3) CheckFiles(bt *Ftpbeat) ([]remoteFile, error) {
+	listing, err := f.list(bt, false)
+	if err != nil {
+		return nil, err
+	}
+
+	files := bt.selectRemote(listing)
+	logp.Info("Files : %v", fileNames(files))
+	return files, nil
+}
+
+// ListTree lists all the objects under the prefix
+func (f *stS3) ListTree(bt *Ftpbeat) ([]remoteFile, error) {
+	return f.list(bt, true)
+}
+
+// list returns the objects under the prefix named relative to it, folder
+// placeholders excluded
+func (f *stS3) list(bt *Ftpbeat, recursive bool) ([]remoteFile, error) {
+	objects, err := f.client.list(bt.s3.Prefix, recursive)
+	if err != nil {
+		logp.Err("%v", err)
+		return nil, err
+	}
+
+	var listing []remoteFile
+	for _, object := range objects {
+		name := strings.TrimPrefix(object.Key, bt.s3.Prefix)
+		if name == "" || strings.HasSuffix(name, "/") {
+			continue
+		}
+		listing = append(listing, remoteFile{Name: name, Size: object.Size, SizeKnown: true, ModTime: object.LastModified})
+	}
+	return listing, nil
+}
+
+// Session shares the client, which pools its connections
+func (f *stS3) Session(bt *Ftpbeat) (integratedFunc, error) {
+	return &stS3{client: f.client}, nil
+}
+
+// Reconnect drops the pooled connections, the aborted copies are kept to be
+// resumed
+func (f *stS3) Reconnect(bt *Ftpbeat) error {
+	f.Quit()
+	return nil
+}
+
+// KeepAlive probes the bucket
+func (f *stS3) KeepAlive() error {
+	return f.client.headBucket()
+}
+
+func (f *stS3) GenEventForLocalFile(file remoteFile, bt *Ftpbeat, b *beat.Beat) error {
+	if err := genEventForLocalFile(file, bt); err != nil {
+		return err
+	}
+	return f.after(file, bt)
+}
+
+func (f *stS3) GenEvent(file remoteFile, bt *Ftpbeat, b *beat.Beat) error {
+	resp, err := f.client.get(bt.s3.Prefix+file.Name, 0, "")
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+		return err
+	}
+	err = publishLines(bt, file, bt.throttle(resp.Body))
+	resp.Body.Close()
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+		return err
+	}
+	return f.after(file, bt)
+}
+
+// after deletes the object once read or got, or moves it under the move_to
+// prefix, copying it there first
+func (f *stS3) after(file remoteFile, bt *Ftpbeat) error {
+	key := bt.s3.Prefix + file.Name
+	var err error
+	switch bt.s3.After {
+	case afterMove:
+		if err = f.client.copy(key, bt.s3.MoveTo+file.Name); err == nil {
+			err = f.client.delete(key)
+		}
+	case afterDelete:
+		err = f.client.delete(key)
+	}
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+	}
+	return err
+}
+
+func (f *stS3) CopyFiles(file remoteFile, bt *Ftpbeat) error {
+	if f.partials == nil {
+		f.partials = make(map[string]partialCopy)
+	}
+	return copyResumable(bt, file, f.partials, func(offset int64, validator string) (*http.Response, error) {
+		return f.client.get(bt.s3.Prefix+file.Name, offset, validator)
+	})
+}
+
+// Open returns the contents of the object
+func (f *stS3) Open(file remoteFile, bt *Ftpbeat) (io.ReadCloser, error) {
+	resp, err := f.client.get(bt.s3.Prefix+file.Name, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Quit closes the idle pooled connections
+func (f *stS3) Quit() {
+	if f.client != nil {
+		closeIdleConnections(f.client.http)
+	}
+}
@@ -0,0 +1,306 @@
+package beater
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/affinity226/ftpbeat/config"
+)
+
+// cronSchedule is a parsed cron expression, each field being the bit set of
+// the values it matches
+type cronSchedule struct {
+	second, minute, hour, dom, month, dow uint64
+	// the day of the month and the day of the week are or-ed when both are
+	// restricted, as cron does
+	domAny, dowAny bool
+	location       *time.Location
+}
+
+// cronField describes the range of a field of the expression
+type cronField struct {
+	min, max uint
+	names    []string
+}
+
+var (
+	secondField = cronField{0, 59, nil}
+	minuteField = cronField{0, 59, nil}
+	hourField   = cronField{0, 23, nil}
+	domField    = cronField{1, 31, nil}
+	monthField  = cronField{1, 12, []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	dowField    = cronField{0, 7, []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+// cronDescriptors are the shorthands of the usual schedules
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+// parseCron parses a cron expression of 5 fields, or 6 with the seconds
+// first, optionally prefixed by CRON_TZ= or TZ= and the timezone it is
+// evaluated in, the local one otherwise
+func parseCron(expr string) (*cronSchedule, error) {
+	s := &cronSchedule{location: time.Local}
+	fields := strings.Fields(expr)
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "CRON_TZ=") || strings.HasPrefix(fields[0], "TZ=")) {
+		name := fields[0][strings.Index(fields[0], "=")+1:]
+		location, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid schedule [%s]: %v", expr, err)
+		}
+		s.location = location
+		fields = fields[1:]
+	}
+	if len(fields) == 1 {
+		if spec, ok := cronDescriptors[fields[0]]; ok {
+			fields = strings.Fields(spec)
+		}
+	}
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("Invalid schedule [%s], expected 5 or 6 fields", expr)
+	}
+
+	var err error
+	for i, f := range []struct {
+		bits  *uint64
+		field cronField
+	}{
+		{&s.second, secondField},
+		{&s.minute, minuteField},
+		{&s.hour, hourField},
+		{&s.dom, domField},
+		{&s.month, monthField},
+		{&s.dow, dowField},
+	} {
+		if *f.bits, err = f.field.parse(fields[i]); err != nil {
+			return nil, fmt.Errorf("Invalid schedule [%s]: %v", expr, err)
+		}
+	}
+	// Sunday is both 0 and 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny = fields[3] == "*" || fields[3] == "?"
+	s.dowAny = fields[5] == "*" || fields[5] == "?"
+	return s, nil
+}
+
+// parse parses a comma separated list of values, ranges and steps
+func (f cronField) parse(s string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		step := uint(1)
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.ParseUint(part[i+1:], 10, 8)
+			if err != nil || n == 0 {
+				return 0, fmt.Errorf("invalid step [%s]", part)
+			}
+			step = uint(n)
+			part = part[:i]
+		}
+
+		var from, to uint
+		switch {
+		case part == "*" || part == "?":
+			from, to = f.min, f.max
+		case strings.Contains(part, "-"):
+			i := strings.Index(part, "-")
+			var err error
+			if from, err = f.value(part[:i]); err != nil {
+				return 0, err
+			}
+			if to, err = f.value(part[i+1:]); err != nil {
+				return 0, err
+			}
+		default:
+			v, err := f.value(part)
+			if err != nil {
+				return 0, err
+			}
+			from, to = v, v
+			// a step after a single value runs to the end of the range
+			if step > 1 {
+				to = f.max
+			}
+		}
+		if from > to {
+			return 0, fmt.Errorf("invalid range [%s]", part)
+		}
+		for v := from; v <= to; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// value parses a number or a name of the field
+func (f cronField) value(s string) (uint, error) {
+	for i, name := range f.names {
+		if name != "" && strings.EqualFold(s, name) {
+			return uint(i), nil
+		}
+	}
+	n, err := strconv.ParseUint(s, 10, 8)
+	if err != nil || uint(n) < f.min || uint(n) > f.max {
+		return 0, fmt.Errorf("invalid value [%s]", s)
+	}
+	return uint(n), nil
+}
+
+// next returns the first time matching the schedule strictly after t, or the
+// zero time when there is none in the next five years
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.In(s.location).Truncate(time.Second).Add(time.Second)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.location)
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.location)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.location)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Truncate(time.Minute).Add(time.Minute)
+			continue
+		}
+		if s.second&(1<<uint(t.Second())) == 0 {
+			t = t.Add(time.Second)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) matchDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// activeWindow is a time of the day, on some days of the week, where the
+// server may be polled
+type activeWindow struct {
+	from, to time.Duration
+	days     map[time.Weekday]bool
+	location *time.Location
+}
+
+// parseActiveWindows parses the active windows from the config
+func parseActiveWindows(configs []config.ActiveWindowConfig) ([]activeWindow, error) {
+	var windows []activeWindow
+	for _, c := range configs {
+		w := activeWindow{location: time.Local}
+		var err error
+		if w.from, err = parseTimeOfDay(c.From); err != nil {
+			return nil, err
+		}
+		if w.to, err = parseTimeOfDay(c.To); err != nil {
+			return nil, err
+		}
+		if c.Timezone != "" {
+			if w.location, err = time.LoadLocation(c.Timezone); err != nil {
+				return nil, err
+			}
+		}
+		if len(c.Days) > 0 {
+			w.days = make(map[time.Weekday]bool)
+			for _, day := range c.Days {
+				weekday, ok := parseWeekday(day)
+				if !ok {
+					return nil, fmt.Errorf("Invalid day [%s] of active window %s-%s", day, c.From, c.To)
+				}
+				w.days[weekday] = true
+			}
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// contains tells whether the time falls in the window. A window spanning
+// midnight belongs to the day it starts on.
+func (w activeWindow) contains(t time.Time) bool {
+	t = t.In(w.location)
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+	day := t.Weekday()
+	switch {
+	case w.from <= w.to:
+		if offset < w.from || offset >= w.to {
+			return false
+		}
+	case offset >= w.from:
+	case offset < w.to:
+		day = t.AddDate(0, 0, -1).Weekday()
+	default:
+		return false
+	}
+	return w.days == nil || w.days[day]
+}
+
+// active tells whether the server may be polled at the time, always when no
+// window is configured
+func (bt *Ftpbeat) active(t time.Time) bool {
+	if len(bt.activeWindows) == 0 {
+		return true
+	}
+	for _, w := range bt.activeWindows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextRun returns when the beat is scheduled next after now, from the cron
+// schedule or every period since the previous scheduled run, skipping the
+// runs missed while busy
+func (bt *Ftpbeat) nextRun(prev, now time.Time) time.Time {
+	if bt.schedule != nil {
+		return bt.schedule.next(now)
+	}
+	next := prev.Add(bt.period)
+	for !next.After(now) {
+		next = next.Add(bt.period)
+	}
+	return next
+}
+
+// jitterDelay returns a random delay up to the configured jitter, so the
+// beats sharing a schedule do not all poll at the same time
+func (bt *Ftpbeat) jitterDelay() time.Duration {
+	if bt.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(bt.random.Int63n(int64(bt.jitter)))
+}
+
+// newRandom returns a source seeded differently by every process
+func newRandom() *rand.Rand {
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
@@ -0,0 +1,191 @@
+package beater
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/affinity226/ftpbeat/config"
+)
+
+func TestCronNext(t *testing.T) {
+	// A Friday
+	from := time.Date(2026, 10, 16, 10, 17, 30, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{"* * * * *", []string{"2026-10-16 10:18:00", "2026-10-16 10:19:00"}},
+		{"*/15 * * * *", []string{"2026-10-16 10:30:00", "2026-10-16 10:45:00", "2026-10-16 11:00:00"}},
+		{"5/20 * * * *", []string{"2026-10-16 10:25:00", "2026-10-16 10:45:00", "2026-10-16 11:05:00"}},
+		{"0 8-10 * * *", []string{"2026-10-17 08:00:00", "2026-10-17 09:00:00", "2026-10-17 10:00:00", "2026-10-18 08:00:00"}},
+		{"0 8-18/4 * * *", []string{"2026-10-16 12:00:00", "2026-10-16 16:00:00", "2026-10-17 08:00:00"}},
+		{"0,30 9,17 * * *", []string{"2026-10-16 17:00:00", "2026-10-16 17:30:00", "2026-10-17 09:00:00"}},
+		{"30 * * * * *", []string{"2026-10-16 10:18:30", "2026-10-16 10:19:30"}},
+		{"*/20 0 11 * * *", []string{"2026-10-16 11:00:00", "2026-10-16 11:00:20", "2026-10-16 11:00:40", "2026-10-17 11:00:00"}},
+		{"0 6 * * mon-fri", []string{"2026-10-19 06:00:00", "2026-10-20 06:00:00"}},
+		{"0 6 * * 7", []string{"2026-10-18 06:00:00", "2026-10-25 06:00:00"}},
+		{"0 6 * * SUN", []string{"2026-10-18 06:00:00"}},
+		{"0 0 1 jan,jul *", []string{"2027-01-01 00:00:00", "2027-07-01 00:00:00"}},
+		{"0 0 31 * *", []string{"2026-10-31 00:00:00", "2026-12-31 00:00:00", "2027-01-31 00:00:00"}},
+		// The day of the month and the day of the week are or-ed
+		{"0 0 20 * mon", []string{"2026-10-19 00:00:00", "2026-10-20 00:00:00", "2026-10-26 00:00:00"}},
+		{"0 0 ? * mon", []string{"2026-10-19 00:00:00", "2026-10-26 00:00:00"}},
+		{"0 0 29 2 *", []string{"2028-02-29 00:00:00", "2032-02-29 00:00:00"}},
+		{"@hourly", []string{"2026-10-16 11:00:00", "2026-10-16 12:00:00"}},
+		{"@weekly", []string{"2026-10-18 00:00:00", "2026-10-25 00:00:00"}},
+		{"@yearly", []string{"2027-01-01 00:00:00"}},
+		// Impossible dates have no next time
+		{"0 0 30 2 *", []string{"0001-01-01 00:00:00"}},
+		{"0 0 31 4,6,9,11 *", []string{"0001-01-01 00:00:00"}},
+	}
+	for _, test := range tests {
+		s, err := parseCron(test.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", test.expr, err)
+		}
+		s.location = time.UTC
+		var got []string
+		next := from
+		for range test.want {
+			next = s.next(next)
+			got = append(got, next.Format("2006-01-02 15:04:05"))
+		}
+		if strings.Join(got, ", ") != strings.Join(test.want, ", ") {
+			t.Errorf("%s: next %v, want %v", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestCronTimezone(t *testing.T) {
+	from := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"CRON_TZ=America/New_York 0 9 * * *", "2026-10-16 13:00:00"},
+		{"TZ=Asia/Tokyo 0 9 * * *", "2026-10-17 00:00:00"},
+		{"CRON_TZ=UTC 0 9 * * *", "2026-10-17 09:00:00"},
+		// New York leaves daylight saving time on November 1st
+		{"CRON_TZ=America/New_York 0 9 1 11 *", "2026-11-01 14:00:00"},
+	}
+	for _, test := range tests {
+		s, err := parseCron(test.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", test.expr, err)
+		}
+		if got := s.next(from).UTC().Format("2006-01-02 15:04:05"); got != test.want {
+			t.Errorf("%s: next %s, want %s", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{"", "expected 5 or 6 fields"},
+		{"* * * *", "expected 5 or 6 fields"},
+		{"* * * * * * *", "expected 5 or 6 fields"},
+		{"@sometimes", "expected 5 or 6 fields"},
+		{"60 * * * *", "invalid value [60]"},
+		{"* 24 * * *", "invalid value [24]"},
+		{"* * 0 * *", "invalid value [0]"},
+		{"* * * 13 *", "invalid value [13]"},
+		{"* * * * 8", "invalid value [8]"},
+		{"* * * foo *", "invalid value [foo]"},
+		{"30-10 * * * *", "invalid range [30-10]"},
+		{"*/0 * * * *", "invalid step [*/0]"},
+		{"*/x * * * *", "invalid step [*/x]"},
+		{"CRON_TZ=Mars/Olympus * * * * *", "Invalid schedule [CRON_TZ=Mars/Olympus * * * * *]"},
+	}
+	for _, test := range tests {
+		_, err := parseCron(test.expr)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("parseCron(%q) = %v, want %q", test.expr, err, test.err)
+		}
+	}
+}
+
+func TestActiveWindows(t *testing.T) {
+	// A Friday
+	at := func(clock string) time.Time {
+		t, _ := time.Parse("2006-01-02 15:04", "2026-10-16 "+clock)
+		return t
+	}
+	saturday := func(clock string) time.Time { return at(clock).AddDate(0, 0, 1) }
+	tests := []struct {
+		name    string
+		windows []config.ActiveWindowConfig
+		time    time.Time
+		want    bool
+	}{
+		{"no window", nil, at("03:00"), true},
+		{"inside", []config.ActiveWindowConfig{{From: "08:00", To: "18:00"}}, at("08:00"), true},
+		{"end excluded", []config.ActiveWindowConfig{{From: "08:00", To: "18:00"}}, at("18:00"), false},
+		{"before", []config.ActiveWindowConfig{{From: "08:00", To: "18:00"}}, at("07:59"), false},
+		{"business days", []config.ActiveWindowConfig{{From: "08:00", To: "18:00", Days: []string{"mon", "fri"}}}, at("12:00"), true},
+		{"weekend", []config.ActiveWindowConfig{{From: "08:00", To: "18:00", Days: []string{"mon", "fri"}}}, saturday("12:00"), false},
+		{"over midnight late", []config.ActiveWindowConfig{{From: "22:00", To: "06:00", Days: []string{"fri"}}}, at("23:00"), true},
+		// After midnight the window belongs to the Friday it started on
+		{"over midnight early", []config.ActiveWindowConfig{{From: "22:00", To: "06:00", Days: []string{"fri"}}}, saturday("05:00"), true},
+		{"over midnight other day", []config.ActiveWindowConfig{{From: "22:00", To: "06:00", Days: []string{"fri"}}}, at("05:00"), false},
+		{"second window", []config.ActiveWindowConfig{{From: "08:00", To: "09:00"}, {From: "17:00", To: "18:00"}}, at("17:30"), true},
+		{"between windows", []config.ActiveWindowConfig{{From: "08:00", To: "09:00"}, {From: "17:00", To: "18:00"}}, at("12:00"), false},
+		{"timezone", []config.ActiveWindowConfig{{From: "08:00", To: "18:00", Timezone: "Asia/Tokyo"}}, at("03:00"), true},
+	}
+	for _, test := range tests {
+		for i := range test.windows {
+			if test.windows[i].Timezone == "" {
+				test.windows[i].Timezone = "UTC"
+			}
+		}
+		windows, err := parseActiveWindows(test.windows)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		bt := &Ftpbeat{activeWindows: windows}
+		if got := bt.active(test.time); got != test.want {
+			t.Errorf("%s: active at %v = %v, want %v", test.name, test.time, got, test.want)
+		}
+	}
+
+	for _, c := range []config.ActiveWindowConfig{
+		{From: "8", To: "18:00"},
+		{From: "08:00", To: "25:00"},
+		{From: "08:00", To: "18:00", Days: []string{"someday"}},
+		{From: "08:00", To: "18:00", Timezone: "Mars/Olympus"},
+	} {
+		if _, err := parseActiveWindows([]config.ActiveWindowConfig{c}); err == nil {
+			t.Errorf("%+v: accepted", c)
+		}
+	}
+}
+
+func TestNextRun(t *testing.T) {
+	prev := time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+	schedule, err := parseCron("CRON_TZ=UTC 0 */6 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		period   time.Duration
+		schedule *cronSchedule
+		now      time.Time
+		want     time.Time
+	}{
+		{"period", time.Minute, nil, prev.Add(10 * time.Second), prev.Add(time.Minute)},
+		{"period on time", time.Minute, nil, prev.Add(time.Minute), prev.Add(2 * time.Minute)},
+		{"period missed while busy", time.Minute, nil, prev.Add(150 * time.Second), prev.Add(3 * time.Minute)},
+		{"schedule", time.Minute, schedule, prev.Add(time.Minute), prev.Add(2 * time.Hour)},
+		{"schedule after a long run", time.Minute, schedule, prev.Add(7 * time.Hour), prev.Add(8 * time.Hour)},
+	}
+	for _, test := range tests {
+		bt := &Ftpbeat{period: test.period, schedule: test.schedule}
+		if got := bt.nextRun(prev, test.now); !got.Equal(test.want) {
+			t.Errorf("%s: nextRun() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package beater
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/logp"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// stSCP gets files by running `scp -f` on the server, for hosts that allow
+// SSH but have no sftp subsystem
+type stSCP struct {
+	conn *deadlineConn
+	con  *ssh.Client
+	// refs is shared with the sessions opened on the same connection
+	refs *sshRefs
+}
+
+func (f *stSCP) Init(bt *Ftpbeat) error {
+	var err error
+	f.conn, f.con, err = dialSSH(bt)
+	if err != nil {
+		logp.Err("%v", err)
+		return err
+	}
+	f.refs = newSSHRefs()
+	return nil
+}
+
+// Login does nothing, authentication is part of the SSH handshake
+func (f *stSCP) Login(bt *Ftpbeat) error {
+	return nil
+}
+
+// CheckFiles lists the remote directory with the configured list command,
+// unless all files are given by name and no file is expected
+func (f *stSCP) CheckFiles(bt *Ftpbeat) ([]remoteFile, error) {
+	if !hasPatterns(bt.files) && len(bt.filter.include) == 0 && len(bt.expectations) == 0 {
+		var files []remoteFile
+		for _, name := range bt.files {
+			files = append(files, remoteFile{Name: name})
+		}
+		return files, nil
+	}
+
+	f.conn.begin()
+	defer f.conn.end()
+
+	session, err := f.con.NewSession()
+	if err != nil {
+		logp.Err("%v", err)
+		return nil, err
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	out, err := session.Output(bt.scpListCommand + " " + quoteRemotePath(bt.remoteDirectory))
+	if err != nil {
+		err = fmt.Errorf("%s: %v %s", bt.scpListCommand, err, strings.TrimSpace(stderr.String()))
+		logp.Err("%v", err)
+		return nil, err
+	}
+
+	// The list command marks directories with a trailing slash
+	var listing []remoteFile
+	for _, name := range strings.Split(string(out), "\n") {
+		name = strings.TrimRight(name, "\r")
+		if name == "" || strings.HasSuffix(name, "/") {
+			continue
+		}
+		listing = append(listing, remoteFile{Name: name})
+	}
+
+	files := bt.selectRemote(listing)
+	logp.Info("Files : %v", fileNames(files))
+	return files, nil
+}
+
+// Session shares the connection, each transfer runs in its own SSH session.
+// The connection stays open until all the sessions sharing it quit.
+func (f *stSCP) Session(bt *Ftpbeat) (integratedFunc, error) {
+	if f.refs == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+	f.refs.acquire()
+	return &stSCP{conn: f.conn, con: f.con, refs: f.refs}, nil
+}
+
+// Reconnect opens a new connection of its own, leaving the previous one to
+// the sessions still sharing it
+func (f *stSCP) Reconnect(bt *Ftpbeat) error {
+	f.Quit()
+	return f.Init(bt)
+}
+
+// KeepAlive probes the SSH connection
+func (f *stSCP) KeepAlive() error {
+	return sshKeepAlive(f.conn, f.con)
+}
+
+func (f *stSCP) GenEventForLocalFile(file remoteFile, bt *Ftpbeat, b *beat.Beat) error {
+	return genEventForLocalFile(file, bt)
+}
+
+func (f *stSCP) GenEvent(file remoteFile, bt *Ftpbeat, b *beat.Beat) error {
+	f.conn.begin()
+	defer f.conn.end()
+	r, err := f.open(path.Join(bt.remoteDirectory, file.Name))
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+		return err
+	}
+	// The listing has no sizes, scp tells it
+	file.Size = r.remaining
+	err = publishLines(bt, file, bt.throttleConn(r, f.conn))
+	r.Close()
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+	}
+	return err
+}
+
+func (f *stSCP) CopyFiles(file remoteFile, bt *Ftpbeat) error {
+	f.conn.begin()
+	defer f.conn.end()
+	r, err := f.open(path.Join(bt.remoteDirectory, file.Name))
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+		return err
+	}
+	defer r.Close()
+
+	err = bt.saveFile(file.Name, bt.throttleConn(r, f.conn))
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+	}
+	return err
+}
+
+func (f *stSCP) Quit() {
+	if f.refs != nil && f.refs.release() {
+		closeSSH(f.conn, f.con)
+	}
+	f.conn, f.con, f.refs = nil, nil, nil
+}
+
+// Open returns the contents of the remote file
+func (f *stSCP) Open(file remoteFile, bt *Ftpbeat) (io.ReadCloser, error) {
+	f.conn.begin()
+	defer f.conn.end()
+	return f.open(path.Join(bt.remoteDirectory, file.Name))
+}
+
+// open starts `scp -f` for the remote file and returns its contents
+func (f *stSCP) open(remotePath string) (*scpReader, error) {
+	session, err := f.con.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	if err := session.Start("scp -pf -- " + quoteRemotePath(remotePath)); err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	r := &scpReader{session: session, stdin: stdin, stdout: bufio.NewReader(stdout)}
+	if err := r.start(); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// scpReader reads a single file sent by the source side of the scp protocol
+type scpReader struct {
+	session   *ssh.Session
+	stdin     io.WriteCloser
+	stdout    *bufio.Reader
+	remaining int64
+	// done is set once the status following the file is read
+	done bool
+}
+
+// start acknowledges the headers up to the file header and its size
+func (r *scpReader) start() error {
+	for {
+		if err := r.ack(); err != nil {
+			return err
+		}
+		line, err := r.stdout.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\n")
+		if line == "" {
+			return fmt.Errorf("scp: unexpected empty message")
+		}
+
+		switch line[0] {
+		case 'T':
+			// Times of the file, sent because of -p
+			continue
+		case 'C':
+			// C<mode> <size> <name>
+			fields := strings.SplitN(line, " ", 3)
+			if len(fields) != 3 {
+				return fmt.Errorf("scp: invalid file header %q", line)
+			}
+			r.remaining, err = strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("scp: invalid file size %q", fields[1])
+			}
+			return r.ack()
+		case '\x01', '\x02':
+			return fmt.Errorf("scp: %s", line[1:])
+		default:
+			return fmt.Errorf("scp: unexpected message %q", line)
+		}
+	}
+}
+
+func (r *scpReader) ack() error {
+	_, err := r.stdin.Write([]byte{0})
+	return err
+}
+
+func (r *scpReader) Read(b []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+	var n int
+	if r.remaining > 0 {
+		if int64(len(b)) > r.remaining {
+			b = b[:r.remaining]
+		}
+		var err error
+		n, err = r.stdout.Read(b)
+		r.remaining -= int64(n)
+		if err == io.EOF && r.remaining > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil || r.remaining > 0 {
+			return n, err
+		}
+	}
+
+	// The file, empty or not, is followed by its status
+	r.done = true
+	status, err := r.stdout.ReadByte()
+	if err != nil {
+		return n, err
+	}
+	if status != 0 {
+		msg, _ := r.stdout.ReadString('\n')
+		return n, fmt.Errorf("scp: %s", strings.TrimSpace(msg))
+	}
+	return n, r.ack()
+}
+
+func (r *scpReader) Close() error {
+	r.stdin.Close()
+	return r.session.Close()
+}
+
+// hasPatterns tells whether any of the names is a glob pattern
+func hasPatterns(names []string) bool {
+	for _, name := range names {
+		if strings.ContainsAny(name, "*?[") {
+			return true
+		}
+	}
+	return false
+}
+
+// shellQuote quotes s for the POSIX shell running remote commands
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// quoteRemotePath quotes a remote path for the shell. A leading "~/" would
+// not be expanded once quoted, it is dropped instead as the remote commands
+// start in the home directory.
+func quoteRemotePath(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		p = strings.TrimLeft(p[1:], "/")
+		if p == "" {
+			p = "."
+		}
+	}
+	return shellQuote(p)
+}
@@ -0,0 +1,89 @@
+package beater
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestQuoteRemotePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"~", "."},
+		{"~/", "."},
+		{"~//", "."},
+		{"~/in", "in"},
+		{"~/in/a.log", "in/a.log"},
+		{"/data/in", "/data/in"},
+		{"in dir/a b.log", "in dir/a b.log"},
+		{"~/it's/a.log", "it's/a.log"},
+		{`"quoted" $HOME *.log`, `"quoted" $HOME *.log`},
+		{"~user/in", "~user/in"},
+	}
+	for _, test := range tests {
+		// The path the remote shell gets once quoted
+		out, err := exec.Command("sh", "-c", "printf %s "+quoteRemotePath(test.path)).Output()
+		if err != nil {
+			t.Fatalf("%s: %v", test.path, err)
+		}
+		if string(out) != test.want {
+			t.Errorf("quoteRemotePath(%q) gives %q to the shell, want %q", test.path, out, test.want)
+		}
+	}
+}
+
+// nopWriteCloser counts the acknowledgements sent to scp
+type nopWriteCloser struct {
+	bytes.Buffer
+}
+
+func (w *nopWriteCloser) Close() error { return nil }
+
+func TestSCPReader(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   string
+		size   int64
+		err    string
+		acks   int
+	}{
+		{"file", "C0644 6 a.log\na1\na2\n\x00", "a1\na2\n", 6, "", 3},
+		{"file with times", "T1760770800 0 1760770800 0\nC0644 2 a b.log\nb1\x00", "b1", 2, "", 4},
+		{"empty file", "C0644 0 e.log\n\x00", "", 0, "", 3},
+		{"missing file", "\x01scp: a.log: No such file or directory\n", "", 0, "scp: scp: a.log: No such file or directory", 1},
+		{"fatal error", "\x02scp: protocol error\n", "", 0, "scp: scp: protocol error", 1},
+		{"invalid header", "C0644 6\n", "", 0, `scp: invalid file header "C0644 6"`, 1},
+		{"invalid size", "C0644 six a.log\n", "", 0, `scp: invalid file size "six"`, 1},
+		{"unexpected message", "D0755 0 dir\n", "", 0, `scp: unexpected message "D0755 0 dir"`, 1},
+		{"truncated", "C0644 6 a.log\na1\n", "a1\n", 6, io.ErrUnexpectedEOF.Error(), 2},
+		{"failed after the data", "C0644 2 a.log\na1\x01read error\n", "a1", 2, "scp: read error", 2},
+	}
+	for _, test := range tests {
+		stdin := &nopWriteCloser{}
+		r := &scpReader{stdin: stdin, stdout: bufio.NewReader(strings.NewReader(test.stream))}
+		err := r.start()
+		var data []byte
+		if err == nil {
+			if r.remaining != test.size {
+				t.Errorf("%s: size %d, want %d", test.name, r.remaining, test.size)
+			}
+			data, err = ioutil.ReadAll(r)
+		}
+		if string(data) != test.want {
+			t.Errorf("%s: read %q, want %q", test.name, data, test.want)
+		}
+		if (err == nil && test.err != "") || (err != nil && err.Error() != test.err) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+		if stdin.Len() != test.acks {
+			t.Errorf("%s: %d acknowledgements, want %d", test.name, stdin.Len(), test.acks)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package beater
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// redacted is printed in place of a secret
+const redacted = "xxxxx"
+
+// readSecretFile returns the secret held by the file, without the trailing
+// line break
+func readSecretFile(name string) (string, error) {
+	name = expandHome(name)
+	info, err := os.Stat(name)
+	if err != nil {
+		return "", fmt.Errorf("Cannot read password file: %v", err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		logp.Warn("Password file %s is accessible by other users", name)
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", fmt.Errorf("Cannot read password file: %v", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// redactURL masks the password of the user info of the URL
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	return u.String()
+}
@@ -0,0 +1,115 @@
+package beater
+
+import (
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// connectRunner makes sure the primary session is usable. A session kept from
+// the previous period is probed first and only replaced when it stopped
+// answering. It must be called with sessionMutex held.
+func (bt *Ftpbeat) connectRunner() error {
+	if bt.connected {
+		err := bt.runner.KeepAlive()
+		if err == nil {
+			return nil
+		}
+		logp.Info("Session to %s is gone, reconnecting: %v", bt.hostname, err)
+		bt.closeSessionsLocked()
+	}
+
+	err := bt.runner.Init(bt)
+	if err != nil {
+		return err
+	}
+	err = bt.runner.Login(bt)
+	if err != nil {
+		bt.runner.Quit()
+		return err
+	}
+	bt.connected = true
+	return nil
+}
+
+// acquireSessions returns up to n live sessions, the primary one first.
+// Additional sessions kept from the previous period are probed and the
+// dead ones replaced. It must be called with sessionMutex held.
+func (bt *Ftpbeat) acquireSessions(n int) []integratedFunc {
+	var alive []integratedFunc
+	for _, session := range bt.sessions {
+		if session.KeepAlive() == nil {
+			alive = append(alive, session)
+		} else {
+			session.Quit()
+		}
+	}
+	bt.sessions = alive
+
+	for len(bt.sessions) < n-1 {
+		session, err := bt.runner.Session(bt)
+		if err != nil {
+			logp.Warn("Could not open additional session, continuing with %d: %v", len(bt.sessions)+1, err)
+			break
+		}
+		bt.sessions = append(bt.sessions, session)
+	}
+
+	sessions := []integratedFunc{bt.runner}
+	for i := 0; i < len(bt.sessions) && len(sessions) < n; i++ {
+		sessions = append(sessions, bt.sessions[i])
+	}
+	return sessions
+}
+
+// releaseSessions marks the sessions as idle. They are closed right away
+// when no idle timeout is configured, otherwise once it expires.
+// It must be called with sessionMutex held.
+func (bt *Ftpbeat) releaseSessions() {
+	bt.lastUsed = time.Now()
+	if bt.idleTimeout <= 0 {
+		bt.closeSessionsLocked()
+		return
+	}
+
+	if bt.idleTimer != nil {
+		bt.idleTimer.Stop()
+	}
+	bt.idleTimer = time.AfterFunc(bt.idleTimeout, bt.closeIdleSessions)
+}
+
+// closeIdleSessions closes the sessions unless they have been used again
+// since the idle timer was armed.
+func (bt *Ftpbeat) closeIdleSessions() {
+	bt.sessionMutex.Lock()
+	defer bt.sessionMutex.Unlock()
+
+	if !bt.connected || time.Since(bt.lastUsed) < bt.idleTimeout {
+		return
+	}
+	logp.Info("Closing sessions to %s idle for %v", bt.hostname, bt.idleTimeout)
+	bt.closeSessionsLocked()
+}
+
+// closeSessions closes the primary and all additional sessions.
+func (bt *Ftpbeat) closeSessions() {
+	bt.sessionMutex.Lock()
+	defer bt.sessionMutex.Unlock()
+
+	if bt.idleTimer != nil {
+		bt.idleTimer.Stop()
+	}
+	bt.closeSessionsLocked()
+}
+
+func (bt *Ftpbeat) closeSessionsLocked() {
+	for _, session := range bt.sessions {
+		session.Quit()
+	}
+	bt.sessions = nil
+
+	if bt.connected {
+		bt.runner.Quit()
+		bt.connected = false
+	}
+}
@@ -0,0 +1,163 @@
+package beater
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sessionRunner counts the calls made to a session
+type sessionRunner struct {
+	integratedFunc
+	mutex      sync.Mutex
+	inits      int
+	quits      int
+	keepAlives int
+	dead       bool
+	failLogin  bool
+}
+
+func (r *sessionRunner) Init(bt *Ftpbeat) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.inits++
+	r.dead = false
+	return nil
+}
+
+func (r *sessionRunner) Login(bt *Ftpbeat) error {
+	if r.failLogin {
+		return fmt.Errorf("530 Login incorrect")
+	}
+	return nil
+}
+
+func (r *sessionRunner) KeepAlive() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.keepAlives++
+	if r.dead {
+		return fmt.Errorf("connection reset")
+	}
+	return nil
+}
+
+func (r *sessionRunner) Quit() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.quits++
+	r.dead = true
+}
+
+func (r *sessionRunner) counts() (inits, quits, keepAlives int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.inits, r.quits, r.keepAlives
+}
+
+func TestConnectRunner(t *testing.T) {
+	tests := []struct {
+		name      string
+		connected bool
+		dead      bool
+		failLogin bool
+		err       bool
+		// calls expected
+		inits, quits, keepAlives int
+	}{
+		{"first connection", false, false, false, false, 1, 0, 0},
+		{"kept session alive", true, false, false, false, 0, 0, 1},
+		{"kept session gone", true, true, false, false, 1, 1, 1},
+		{"login failure", false, false, true, true, 1, 1, 0},
+	}
+	for _, test := range tests {
+		runner := &sessionRunner{dead: test.dead, failLogin: test.failLogin}
+		bt := &Ftpbeat{runner: runner, connected: test.connected}
+
+		err := bt.connectRunner()
+		if (err != nil) != test.err {
+			t.Errorf("%s: error %v", test.name, err)
+		}
+		if bt.connected != !test.err {
+			t.Errorf("%s: connected %v", test.name, bt.connected)
+		}
+		inits, quits, keepAlives := runner.counts()
+		if inits != test.inits || quits != test.quits || keepAlives != test.keepAlives {
+			t.Errorf("%s: %d inits, %d quits and %d keepalives, want %d, %d and %d", test.name,
+				inits, quits, keepAlives, test.inits, test.quits, test.keepAlives)
+		}
+	}
+}
+
+func TestReleaseSessions(t *testing.T) {
+	connect := func(idleTimeout time.Duration) (*Ftpbeat, *sessionRunner, *sessionRunner) {
+		runner, session := &sessionRunner{}, &sessionRunner{}
+		bt := &Ftpbeat{runner: runner, idleTimeout: idleTimeout}
+		bt.sessionMutex.Lock()
+		defer bt.sessionMutex.Unlock()
+		if err := bt.connectRunner(); err != nil {
+			t.Fatal(err)
+		}
+		bt.sessions = []integratedFunc{session}
+		return bt, runner, session
+	}
+	release := func(bt *Ftpbeat) {
+		bt.sessionMutex.Lock()
+		defer bt.sessionMutex.Unlock()
+		bt.releaseSessions()
+	}
+	connected := func(bt *Ftpbeat) bool {
+		bt.sessionMutex.Lock()
+		defer bt.sessionMutex.Unlock()
+		return bt.connected
+	}
+
+	// No idle timeout disconnects after every period
+	bt, runner, session := connect(0)
+	release(bt)
+	if connected(bt) || bt.sessions != nil {
+		t.Errorf("sessions kept with no idle timeout")
+	}
+	if _, quits, _ := runner.counts(); quits != 1 {
+		t.Errorf("primary session quit %d times", quits)
+	}
+	if _, quits, _ := session.counts(); quits != 1 {
+		t.Errorf("additional session quit %d times", quits)
+	}
+
+	// The sessions are kept until they stay idle for the timeout
+	bt, runner, session = connect(100 * time.Millisecond)
+	release(bt)
+	time.Sleep(60 * time.Millisecond)
+	if !connected(bt) {
+		t.Fatalf("sessions closed before the idle timeout")
+	}
+
+	// Being used again rearms the timeout, the kept session being probed
+	bt.sessionMutex.Lock()
+	if err := bt.connectRunner(); err != nil {
+		t.Fatal(err)
+	}
+	bt.acquireSessions(2)
+	bt.releaseSessions()
+	bt.sessionMutex.Unlock()
+	if inits, _, keepAlives := runner.counts(); inits != 1 || keepAlives != 1 {
+		t.Errorf("kept session reconnected: %d inits and %d keepalives", inits, keepAlives)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !connected(bt) {
+		t.Fatalf("sessions closed before the rearmed idle timeout")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if connected(bt) {
+		t.Errorf("sessions still open after the idle timeout")
+	}
+	if _, quits, _ := runner.counts(); quits != 1 {
+		t.Errorf("primary session quit %d times", quits)
+	}
+	if _, quits, _ := session.counts(); quits != 1 {
+		t.Errorf("additional session quit %d times", quits)
+	}
+}
@@ -1,161 +1,237 @@
 package beater
 
 import (
-	"bufio"
 	"fmt"
 	"github.com/elastic/beats/libbeat/beat"
-	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/logp"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"time"
 )
 
 type stSFTP struct {
+	conn   *deadlineConn
 	con    *ssh.Client
 	client *sftp.Client
+	// refs is shared with the sessions opened on the same connection
+	refs *sshRefs
 }
 
 func (f *stSFTP) Init(bt *Ftpbeat) error {
 	var err error
-	var auths []ssh.AuthMethod
-	auths = append(auths, ssh.Password(bt.password))
-	config := ssh.ClientConfig{
-		User: bt.username,
-		Auth: auths,
-	}
-	f.con, err = ssh.Dial("tcp", fmt.Sprintf("%s:%s", bt.hostname, bt.port), &config)
+	f.conn, f.con, err = dialSSH(bt)
 	if err != nil {
-		logp.Err(fmt.Sprintf("%v", err))
+		logp.Err("%v", err)
 		return err
 	}
-
+	f.refs = newSSHRefs()
 	return nil
 }
 
 func (f *stSFTP) Login(bt *Ftpbeat) error {
 	var err error
+	f.conn.begin()
+	defer f.conn.end()
 	f.client, err = sftp.NewClient(f.con, sftp.MaxPacket(1<<15))
 	if err != nil {
-		logp.Err(fmt.Sprintf("%v", err))
+		logp.Err("%v", err)
 	}
 	return err
 
 }
 
-func (f *stSFTP) CheckFiles(bt *Ftpbeat) error {
-	var temp []string
-	for _, fn := range bt.files {
-		if strings.ContainsAny(fn, "* | ?") {
-			list, err := f.client.Glob(filepath.Join(bt.remoteDirectory, fn))
-			if err == nil {
-				for _, fPath := range list {
-					_, fName := filepath.Split(fPath)
-					temp = append(temp, fName)
-				}
-			} else {
-				logp.Err(fmt.Sprintf("%v", err))
-				return err
-			}
-		} else {
-			temp = append(temp, fn)
+func (f *stSFTP) CheckFiles(bt *Ftpbeat) ([]remoteFile, error) {
+	f.conn.begin()
+	defer f.conn.end()
+
+	infos, err := f.client.ReadDir(bt.remoteDirectory)
+	if err != nil {
+		logp.Err("%v", err)
+		return nil, err
+	}
+
+	var listing []remoteFile
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
 		}
+		listing = append(listing, sftpFile(info.Name(), info))
 	}
-	bt.files = temp
-	logp.Info("Files : %v", bt.files)
-	return nil
 
-}
+	files := bt.selectRemote(listing)
+	logp.Info("Files : %v", fileNames(files))
+	return files, nil
 
-func (f *stSFTP) GenEventForLocalFile(file string, bt *Ftpbeat, b *beat.Beat) error {
-	var event common.MapStr
-	//r, err := f.client.Open(filepath.Join(bt.currentDirectory, file))
-	r, err := os.Open(filepath.Join(bt.currentDirectory, file))
-	if err != nil {
-		logp.Err(fmt.Sprintf("%v", err))
-		return err
-	} else {
-		scan := bufio.NewScanner(r)
+}
 
-		if err := scan.Err(); err != nil {
-			logp.Err(fmt.Sprintf("%v", err))
-			r.Close()
-			return err
+// ListTree walks the remote directory and its sub directories
+func (f *stSFTP) ListTree(bt *Ftpbeat) ([]remoteFile, error) {
+	f.conn.begin()
+	defer f.conn.end()
+
+	root := strings.TrimSuffix(bt.remoteDirectory, "/") + "/"
+	var files []remoteFile
+	walker := f.client.Walk(bt.remoteDirectory)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			logp.Err("%v", err)
+			return nil, err
 		}
-		for scan.Scan() {
-			event = common.MapStr{
-				"@timestamp": common.Time(time.Now()),
-				"type":       bt.connectType,
-			}
-			event["message"] = scan.Text()
-			//b.Events.PublishEvent(event)
-			bt.client.PublishEvent(event)
-			event = nil
+		info := walker.Stat()
+		if !info.Mode().IsRegular() {
+			continue
 		}
-		r.Close()
+		name := strings.TrimPrefix(walker.Path(), root)
+		files = append(files, sftpFile(name, info))
 	}
-	return nil
+	return files, nil
+}
 
+// Session shares the connection, as an sftp.Client multiplexes concurrent
+// requests over the one ssh.Client. The connection stays open until all the
+// sessions sharing it quit.
+func (f *stSFTP) Session(bt *Ftpbeat) (integratedFunc, error) {
+	if f.refs == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+	f.refs.acquire()
+	return &stSFTP{conn: f.conn, con: f.con, client: f.client, refs: f.refs}, nil
 }
-func (f *stSFTP) GenEvent(file string, bt *Ftpbeat, b *beat.Beat) error {
-	var event common.MapStr
-	r, err := f.client.Open(filepath.Join(bt.remoteDirectory, file))
+
+// Reconnect opens a new connection of its own, leaving the previous one to
+// the sessions still sharing it
+func (f *stSFTP) Reconnect(bt *Ftpbeat) error {
+	f.Quit()
+	if err := f.Init(bt); err != nil {
+		return err
+	}
+	return f.Login(bt)
+}
+
+// KeepAlive probes the SSH connection
+func (f *stSFTP) KeepAlive() error {
+	return sshKeepAlive(f.conn, f.con)
+}
+
+func (f *stSFTP) GenEventForLocalFile(file remoteFile, bt *Ftpbeat, b *beat.Beat) error {
+	return genEventForLocalFile(file, bt)
+}
+
+func (f *stSFTP) GenEvent(file remoteFile, bt *Ftpbeat, b *beat.Beat) error {
+	f.conn.begin()
+	defer f.conn.end()
+	r, err := f.client.Open(filepath.Join(bt.remoteDirectory, file.Name))
 	if err != nil {
-		logp.Err(fmt.Sprintf("%v", err))
+		logp.Err("%v", err)
 		return err
-	} else {
-		scan := bufio.NewScanner(r)
+	}
+	err = publishLines(bt, file, bt.throttleConn(r, f.conn))
+	r.Close()
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+	}
+	return err
+}
 
-		if err := scan.Err(); err != nil {
-			logp.Err(fmt.Sprintf("%v", err))
-			r.Close()
+func (f *stSFTP) CopyFiles(file remoteFile, bt *Ftpbeat) error {
+	f.conn.begin()
+	defer f.conn.end()
+	r, err := f.client.Open(filepath.Join(bt.remoteDirectory, file.Name))
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+		return err
+	} else {
+		err = bt.saveFile(file.Name, bt.throttleConn(r, f.conn))
+		r.Close()
+		if err != nil {
+			logp.Err("%v : %s", err, file.Name)
 			return err
 		}
-		for scan.Scan() {
-			event = common.MapStr{
-				"@timestamp": common.Time(time.Now()),
-				"type":       bt.connectType,
-			}
-			event["message"] = scan.Text()
-			//b.Events.PublishEvent(event)
-			bt.client.PublishEvent(event)
-			event = nil
-		}
-		r.Close()
 	}
 	return nil
 
 }
 
-func (f *stSFTP) CopyFiles(file string, bt *Ftpbeat) error {
-	r, err := f.client.Open(filepath.Join(bt.remoteDirectory, file))
+// Open returns the contents of the remote file
+func (f *stSFTP) Open(file remoteFile, bt *Ftpbeat) (io.ReadCloser, error) {
+	f.conn.begin()
+	defer f.conn.end()
+	return f.client.Open(filepath.Join(bt.remoteDirectory, file.Name))
+}
+
+// CheckRemoteDirectory checks the remote directory is there
+func (f *stSFTP) CheckRemoteDirectory(bt *Ftpbeat) error {
+	f.conn.begin()
+	defer f.conn.end()
+	info, err := f.client.Stat(bt.remoteDirectory)
+	if err == nil && !info.IsDir() {
+		err = fmt.Errorf("%s is not a directory", bt.remoteDirectory)
+	}
 	if err != nil {
-		logp.Err(fmt.Sprintf("%v : %s", err, file))
+		logp.Err("%v", err)
+	}
+	return err
+}
+
+func (f *stSFTP) PutFile(file remoteFile, bt *Ftpbeat) error {
+	r, err := os.Open(filepath.Join(bt.currentDirectory, file.Name))
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
 		return err
-	} else {
-		outf, err := os.Create(filepath.Join(bt.currentDirectory, file))
-		if err != nil {
-			r.Close()
-			logp.Err(fmt.Sprintf("%v : %s", err, file))
-			return err
+	}
+	defer r.Close()
+
+	f.conn.begin()
+	defer f.conn.end()
+	tmp := path.Join(bt.remoteDirectory, bt.tempName(file.Name))
+	dst := path.Join(bt.remoteDirectory, file.Name)
+	w, err := f.client.Create(tmp)
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+		return err
+	}
+	_, err = io.Copy(w, bt.throttleConn(r, f.conn))
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+		return err
+	}
+
+	// SFTP version 3 does not rename over an existing file
+	if err = f.client.Rename(tmp, dst); err != nil {
+		if f.client.Remove(dst) == nil {
+			err = f.client.Rename(tmp, dst)
 		}
-		io.Copy(outf, r)
-		outf.Close()
-		r.Close()
 	}
-	return nil
+	if err != nil {
+		logp.Err("%v : %s", err, file.Name)
+	}
+	return err
+}
 
+// sftpFile returns the file with its metadata, the owner being given by ids
+func sftpFile(name string, info os.FileInfo) remoteFile {
+	file := remoteFile{Name: name, Size: info.Size(), SizeKnown: true, ModTime: info.ModTime(), Mode: info.Mode()}
+	if stat, ok := info.Sys().(*sftp.FileStat); ok {
+		file.Owner = strconv.FormatUint(uint64(stat.UID), 10)
+		file.Group = strconv.FormatUint(uint64(stat.GID), 10)
+	}
+	return file
 }
 
 func (f *stSFTP) Quit() {
-	if f.con != nil {
-		f.con.Close()
-	}
-	if f.client != nil {
-		f.client.Close()
+	if f.refs != nil && f.refs.release() {
+		if f.client != nil {
+			f.client.Close()
+		}
+		closeSSH(f.conn, f.con)
 	}
+	f.conn, f.con, f.client, f.refs = nil, nil, nil, nil
 }
@@ -0,0 +1,305 @@
+package beater
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshHop is one SSH server on the way to the configured one, which is the
+// last hop. Every hop has its own credentials and host key verification.
+type sshHop struct {
+	addr       string
+	username   string
+	password   string
+	keyFile    string
+	knownHosts string
+	hostKey    string
+}
+
+// clientConfig builds the SSH client configuration of the hop
+func (h sshHop) clientConfig(bt *Ftpbeat) (*ssh.ClientConfig, error) {
+	var auths []ssh.AuthMethod
+	if h.keyFile != "" {
+		pemBytes, err := ioutil.ReadFile(expandHome(h.keyFile))
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(pemBytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", h.keyFile, err)
+		}
+		auths = append(auths, ssh.PublicKeys(signer))
+	}
+	if h.password != "" {
+		auths = append(auths, ssh.Password(h.password))
+	}
+
+	callback, err := hostKeyCallback(h.knownHosts, h.hostKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:            h.username,
+		Auth:            auths,
+		HostKeyCallback: callback,
+		Timeout:         bt.connectTimeout,
+	}, nil
+}
+
+// dialSSH opens an authenticated SSH connection to the configured server,
+// shared by the runners built on top of SSH. Jump hosts are gone through by
+// opening a direct-tcpip channel to the next hop on each of them.
+func dialSSH(bt *Ftpbeat) (*deadlineConn, *ssh.Client, error) {
+	var dconn *deadlineConn
+	var client *ssh.Client
+	closeAll := func() {
+		if dconn != nil {
+			dconn.Close()
+		}
+	}
+
+	for _, hop := range bt.sshHops {
+		config, err := hop.clientConfig(bt)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+
+		var conn net.Conn
+		if client == nil {
+			tcpConn, err := bt.dialer.Dial("tcp", hop.addr)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			// The SSH connection is read continuously in the background, so
+			// the timeout is only enforced while a command or transfer is
+			// running. Both share the connection, the longer timeout
+			// applies. All hops go through this first connection.
+			timeout := bt.commandTimeout
+			if bt.idleReadTimeout > timeout {
+				timeout = bt.idleReadTimeout
+			}
+			dconn = newDeadlineConn(tcpConn, timeout, false)
+			conn = dconn
+		} else {
+			dconn.begin()
+			conn, err = client.Dial("tcp", hop.addr)
+			dconn.end()
+			if err != nil {
+				closeAll()
+				return nil, nil, fmt.Errorf("%s: %v", hop.addr, err)
+			}
+		}
+
+		dconn.begin()
+		c, chans, reqs, err := ssh.NewClientConn(conn, hop.addr, config)
+		dconn.end()
+		if err != nil {
+			conn.Close()
+			closeAll()
+			return nil, nil, fmt.Errorf("%s: %v", hop.addr, err)
+		}
+		client = ssh.NewClient(c, chans, reqs)
+	}
+	return dconn, client, nil
+}
+
+// closeSSH closes the client and the connection to the first hop, which
+// takes down the connections to all the jump hosts
+func closeSSH(conn *deadlineConn, con *ssh.Client) {
+	if con != nil {
+		con.Close()
+	}
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// sshRefs counts the sessions sharing an SSH connection, the last one to
+// quit closes it
+type sshRefs struct {
+	mutex sync.Mutex
+	n     int
+}
+
+func newSSHRefs() *sshRefs {
+	return &sshRefs{n: 1}
+}
+
+func (r *sshRefs) acquire() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.n++
+}
+
+// release tells whether the connection is no longer used
+func (r *sshRefs) release() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.n--
+	return r.n == 0
+}
+
+// sshKeepAlive sends an OpenSSH style keepalive request, any reply means the
+// connection is still up
+func sshKeepAlive(conn *deadlineConn, con *ssh.Client) error {
+	if con == nil {
+		return fmt.Errorf("not connected")
+	}
+	conn.begin()
+	defer conn.end()
+	_, _, err := con.SendRequest("keepalive@openssh.com", true, nil)
+	return err
+}
+
+// hostKeyCallback verifies host keys against a known_hosts file and/or a
+// pinned fingerprint. Without either any host key is accepted, see
+// warnUnverifiedHosts.
+func hostKeyCallback(knownHostsFile, fingerprint string) (func(string, net.Addr, ssh.PublicKey) error, error) {
+	if knownHostsFile == "" && fingerprint == "" {
+		return nil, nil
+	}
+
+	var knownHosts []byte
+	if knownHostsFile != "" {
+		var err error
+		knownHosts, err = ioutil.ReadFile(expandHome(knownHostsFile))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if fingerprint != "" && fingerprint != ssh.FingerprintSHA256(key) && fingerprint != ssh.FingerprintLegacyMD5(key) {
+			return fmt.Errorf("host key of %s does not match %s, got %s", hostname, fingerprint, ssh.FingerprintSHA256(key))
+		}
+		if knownHosts != nil {
+			return checkKnownHosts(knownHosts, hostname, key)
+		}
+		return nil
+	}, nil
+}
+
+// checkKnownHosts looks for the host key in the contents of a known_hosts
+// file, hashed host names included
+func checkKnownHosts(knownHosts []byte, hostname string, key ssh.PublicKey) error {
+	host, port, err := net.SplitHostPort(hostname)
+	if err != nil {
+		host, port = hostname, "22"
+	}
+	candidate := host
+	if port != "22" {
+		candidate = "[" + host + "]:" + port
+	}
+
+	found := false
+	rest := knownHosts
+	for len(rest) > 0 {
+		var marker string
+		var hosts []string
+		var pubKey ssh.PublicKey
+		marker, hosts, pubKey, _, rest, err = ssh.ParseKnownHosts(rest)
+		if err != nil {
+			break
+		}
+		if marker == "cert-authority" || !matchKnownHost(hosts, candidate) {
+			continue
+		}
+
+		same := bytes.Equal(pubKey.Marshal(), key.Marshal())
+		if marker == "revoked" && same {
+			return fmt.Errorf("host key of %s is revoked", hostname)
+		}
+		if same {
+			found = true
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("host key of %s is not in known_hosts, got %s", hostname, ssh.FingerprintSHA256(key))
+	}
+	return nil
+}
+
+// matchKnownHost tells whether one of the host patterns of a known_hosts
+// line matches the candidate
+func matchKnownHost(patterns []string, candidate string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+
+		var ok bool
+		if strings.HasPrefix(pattern, "|1|") {
+			ok = matchHashedHost(pattern, candidate)
+		} else {
+			ok = matchHostPattern(pattern, candidate)
+		}
+		if ok && negated {
+			return false
+		}
+		matched = matched || ok
+	}
+	return matched
+}
+
+// matchHostPattern matches a host pattern where only * and ? are wildcards,
+// brackets being part of "[host]:port" names
+func matchHostPattern(pattern, candidate string) bool {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.Replace(expr, `\*`, ".*", -1)
+	expr = strings.Replace(expr, `\?`, ".", -1)
+	ok, _ := regexp.MatchString("^"+expr+"$", candidate)
+	return ok
+}
+
+// matchHashedHost matches a "|1|salt|hash" hashed host name
+func matchHashedHost(pattern, candidate string) bool {
+	parts := strings.Split(pattern, "|")
+	if len(parts) != 4 {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	hash, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(candidate))
+	return hmac.Equal(mac.Sum(nil), hash)
+}
+
+// warnUnverifiedHosts warns about every hop accepting any host key
+func warnUnverifiedHosts(hops []sshHop) {
+	for _, hop := range hops {
+		if hop.knownHosts == "" && hop.hostKey == "" {
+			logp.Warn("Host key of %s is not verified, set known_hosts or host_key", hop.addr)
+		}
+	}
+}
+
+// expandHome replaces a leading ~ by the home directory
+func expandHome(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		return filepath.Join(os.Getenv("HOME"), p[1:])
+	}
+	return p
+}
@@ -0,0 +1,247 @@
+package beater
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub
+}
+
+// hashHost hashes a host name the way ssh-keygen -H does
+func hashHost(host string) string {
+	salt := []byte("0123456789abcdefghij")
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return "|1|" + base64.StdEncoding.EncodeToString(salt) + "|" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestCheckKnownHosts(t *testing.T) {
+	key := newTestHostKey(t)
+	other := newTestHostKey(t)
+	line := func(hosts string, key ssh.PublicKey) string {
+		return hosts + " " + string(ssh.MarshalAuthorizedKey(key))
+	}
+
+	tests := []struct {
+		name       string
+		knownHosts string
+		hostname   string
+		err        string
+	}{
+		{"plain host", line("sftp.example.com", key), "sftp.example.com:22", ""},
+		{"several hosts", line("other.example.com,sftp.example.com,10.0.0.1", key), "10.0.0.1:22", ""},
+		{"other port", line("[sftp.example.com]:2222", key), "sftp.example.com:2222", ""},
+		{"other port not listed", line("sftp.example.com", key), "sftp.example.com:2222", "not in known_hosts"},
+		{"wildcard", line("*.example.com", key), "sftp.example.com:22", ""},
+		{"single character wildcard", line("sftp?.example.com", key), "sftp1.example.com:22", ""},
+		{"negated", line("!sftp.example.com,*.example.com", key), "sftp.example.com:22", "not in known_hosts"},
+		{"hashed host", line(hashHost("sftp.example.com"), key), "sftp.example.com:22", ""},
+		{"hashed host with port", line(hashHost("[sftp.example.com]:2222"), key), "sftp.example.com:2222", ""},
+		{"hashed other host", line(hashHost("ftp.example.com"), key), "sftp.example.com:22", "not in known_hosts"},
+		{"other key", line("sftp.example.com", other), "sftp.example.com:22", "not in known_hosts"},
+		{"key among others", line("sftp.example.com", other) + line("sftp.example.com", key), "sftp.example.com:22", ""},
+		{"revoked", line("@revoked sftp.example.com", key) + line("sftp.example.com", key), "sftp.example.com:22", "is revoked"},
+		{"comments", "# known hosts\n\n" + line("sftp.example.com", key), "sftp.example.com:22", ""},
+	}
+	for _, test := range tests {
+		err := checkKnownHosts([]byte(test.knownHosts), test.hostname, key)
+		if test.err == "" && err != nil {
+			t.Errorf("%s: checkKnownHosts() = %v", test.name, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: checkKnownHosts() = %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestHostKeyCallbackFingerprint(t *testing.T) {
+	key := newTestHostKey(t)
+
+	callback, err := hostKeyCallback("", "")
+	if err != nil || callback != nil {
+		t.Errorf("hostKeyCallback() without verification = %v, %v", callback != nil, err)
+	}
+
+	for _, fingerprint := range []string{ssh.FingerprintSHA256(key), ssh.FingerprintLegacyMD5(key)} {
+		callback, err := hostKeyCallback("", fingerprint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := callback("sftp.example.com:22", nil, key); err != nil {
+			t.Errorf("%s: %v", fingerprint, err)
+		}
+		if err := callback("sftp.example.com:22", nil, newTestHostKey(t)); err == nil {
+			t.Errorf("%s: other key accepted", fingerprint)
+		}
+	}
+}
+
+// sshStandIn accepts SSH connections with any password and answers their
+// keepalive requests. It counts the connections still open.
+type sshStandIn struct {
+	ln    net.Listener
+	mutex sync.Mutex
+	open  int
+}
+
+func newSSHStandIn(t *testing.T) *sshStandIn {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) { return nil, nil },
+	}
+	config.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &sshStandIn{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, config)
+		}
+	}()
+	return s
+}
+
+func (s *sshStandIn) serve(conn net.Conn, config *ssh.ServerConfig) {
+	c, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	s.mutex.Lock()
+	s.open++
+	s.mutex.Unlock()
+	go func() {
+		for req := range reqs {
+			if req.WantReply {
+				req.Reply(true, nil)
+			}
+		}
+	}()
+	go func() {
+		for ch := range chans {
+			ch.Reject(ssh.Prohibited, "no channels")
+		}
+	}()
+	c.Wait()
+	s.mutex.Lock()
+	s.open--
+	s.mutex.Unlock()
+}
+
+// connections waits for the connections left open to settle
+func (s *sshStandIn) connections(want int) int {
+	var open int
+	for i := 0; i < 100; i++ {
+		s.mutex.Lock()
+		open = s.open
+		s.mutex.Unlock()
+		if open == want {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return open
+}
+
+func (s *sshStandIn) beat() *Ftpbeat {
+	return &Ftpbeat{
+		dialer:  &net.Dialer{Timeout: time.Second},
+		sshHops: []sshHop{{addr: s.ln.Addr().String(), username: "ftpbeat", password: "secret"}},
+	}
+}
+
+// The sessions of a pool share the SSH connection of the primary one, which
+// stays open until the last of them quits
+func TestSharedSSHConnection(t *testing.T) {
+	standIn := newSSHStandIn(t)
+	bt := standIn.beat()
+
+	primary := &stSCP{}
+	if err := primary.Init(bt); err != nil {
+		t.Fatal(err)
+	}
+	first, err := primary.Session(bt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := primary.Session(bt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if open := standIn.connections(1); open != 1 {
+		t.Errorf("%d connections for 3 sessions, want 1", open)
+	}
+
+	// The primary gets a connection of its own, the others keep theirs
+	if err := primary.Reconnect(bt); err != nil {
+		t.Fatal(err)
+	}
+	for i, session := range []integratedFunc{primary, first, second} {
+		if err := session.KeepAlive(); err != nil {
+			t.Errorf("session %d after the reconnect: %v", i, err)
+		}
+	}
+	if open := standIn.connections(2); open != 2 {
+		t.Errorf("%d connections after the reconnect, want 2", open)
+	}
+
+	first.Quit()
+	first.Quit()
+	if err := second.KeepAlive(); err != nil {
+		t.Errorf("session left after another quit: %v", err)
+	}
+	if open := standIn.connections(2); open != 2 {
+		t.Errorf("%d connections with a session left, want 2", open)
+	}
+	second.Quit()
+	if open := standIn.connections(1); open != 1 {
+		t.Errorf("%d connections once the shared one is unused, want 1", open)
+	}
+	if err := second.KeepAlive(); err == nil {
+		t.Errorf("session alive after quitting")
+	}
+
+	primary.Quit()
+	if open := standIn.connections(0); open != 0 {
+		t.Errorf("%d connections after quitting, want 0", open)
+	}
+	if _, err := primary.Session(bt); err == nil {
+		t.Errorf("session of a closed connection")
+	}
+}
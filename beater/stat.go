@@ -0,0 +1,76 @@
+package beater
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// stat publishes the metadata of the matching remote files instead of their
+// contents, one event per file, and a summary of the directory. The summary
+// is published even when there are no files, for alerting on missing feeds.
+func (bt *Ftpbeat) stat() error {
+	files, err := bt.runner.CheckFiles(bt)
+	if err != nil {
+		return err
+	}
+	bt.orderFiles(files)
+
+	now := time.Now()
+	var total int64
+	var newest, oldest *remoteFile
+	for i, file := range files {
+		fields := file.fields()
+		fields["path"] = path.Join(bt.remoteDirectory, file.Name)
+		if !file.ModTime.IsZero() {
+			fields["age_s"] = int64(now.Sub(file.ModTime).Seconds())
+		}
+		bt.client.PublishEvent(common.MapStr{
+			"@timestamp": common.Time(now),
+			"type":       bt.connectType,
+			"message":    fmt.Sprintf("File %s", file.Name),
+			"file":       fields,
+		})
+
+		total += file.Size
+		if file.ModTime.IsZero() {
+			continue
+		}
+		if newest == nil || file.ModTime.After(newest.ModTime) {
+			newest = &files[i]
+		}
+		if oldest == nil || file.ModTime.Before(oldest.ModTime) {
+			oldest = &files[i]
+		}
+	}
+
+	summary := common.MapStr{
+		"path":  bt.remoteDirectory,
+		"files": len(files),
+		"bytes": total,
+	}
+	if newest != nil {
+		summary["newest"] = common.MapStr{
+			"name":  newest.Name,
+			"mtime": common.Time(newest.ModTime),
+			"age_s": int64(now.Sub(newest.ModTime).Seconds()),
+		}
+		summary["oldest"] = common.MapStr{
+			"name":  oldest.Name,
+			"mtime": common.Time(oldest.ModTime),
+			"age_s": int64(now.Sub(oldest.ModTime).Seconds()),
+		}
+	}
+
+	logp.Info("Directory : %v", summary)
+	bt.client.PublishEvent(common.MapStr{
+		"@timestamp": common.Time(now),
+		"type":       bt.connectType,
+		"message":    fmt.Sprintf("Directory %s", bt.remoteDirectory),
+		"directory":  summary,
+	})
+	return nil
+}
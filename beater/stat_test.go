@@ -0,0 +1,106 @@
+package beater
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestStat(t *testing.T) {
+	bt := localBeat(t, "*.csv")
+	bt.orderBy = orderByName
+	now := time.Now().Truncate(time.Second)
+	for _, file := range []struct {
+		name     string
+		contents string
+		age      time.Duration
+	}{
+		{"a.csv", "1,2\n", time.Hour},
+		{"b.csv", "1,2,3\n", 2 * time.Hour},
+		{"c.txt", "not selected\n", 3 * time.Hour},
+	} {
+		name := filepath.Join(bt.remoteDirectory, file.name)
+		writeFile(t, name, file.contents)
+		if err := os.Chmod(name, 0640); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(-file.age)
+		if err := os.Chtimes(name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bt.runner = &stLocal{}
+	if err := bt.runner.Init(bt); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bt.stat(); err != nil {
+		t.Fatal(err)
+	}
+	client := bt.client.(*testClient)
+	if got := strings.Join(client.messages(), "|"); got != "File a.csv|File b.csv|Directory "+bt.remoteDirectory {
+		t.Fatalf("published %q", got)
+	}
+
+	a := client.events[0]["file"].(common.MapStr)
+	want := common.MapStr{
+		"name":  "a.csv",
+		"size":  int64(4),
+		"mtime": common.Time(now.Add(-time.Hour)),
+		"mode":  "0640",
+		"path":  filepath.Join(bt.remoteDirectory, "a.csv"),
+	}
+	for key, value := range want {
+		if a[key] != value {
+			t.Errorf("file %s = %v, want %v", key, a[key], value)
+		}
+	}
+	// The age is taken when the run starts
+	if age, _ := a["age_s"].(int64); age < 3600 || age > 3660 {
+		t.Errorf("file age_s = %v, want about an hour", a["age_s"])
+	}
+
+	summary := client.events[2]["directory"].(common.MapStr)
+	if summary["path"] != bt.remoteDirectory || summary["files"] != 2 || summary["bytes"] != int64(10) {
+		t.Errorf("summary %v", summary)
+	}
+	newest, _ := summary["newest"].(common.MapStr)
+	oldest, _ := summary["oldest"].(common.MapStr)
+	if newest["name"] != "a.csv" || newest["mtime"] != common.Time(now.Add(-time.Hour)) {
+		t.Errorf("newest %v, want a.csv", newest)
+	}
+	if oldest["name"] != "b.csv" || oldest["mtime"] != common.Time(now.Add(-2*time.Hour)) {
+		t.Errorf("oldest %v, want b.csv", oldest)
+	}
+	if age, _ := oldest["age_s"].(int64); age < 7200 || age > 7260 {
+		t.Errorf("oldest age_s = %v, want about two hours", oldest["age_s"])
+	}
+}
+
+// The summary tells when the directory has no matching file
+func TestStatEmpty(t *testing.T) {
+	bt := localBeat(t, "*.csv")
+	bt.runner = &stLocal{}
+	if err := bt.runner.Init(bt); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bt.stat(); err != nil {
+		t.Fatal(err)
+	}
+	client := bt.client.(*testClient)
+	if len(client.events) != 1 {
+		t.Fatalf("published %v, want the summary only", client.messages())
+	}
+	summary := client.events[0]["directory"].(common.MapStr)
+	if summary["files"] != 0 || summary["bytes"] != int64(0) {
+		t.Errorf("summary %v", summary)
+	}
+	if _, ok := summary["newest"]; ok {
+		t.Errorf("newest file in an empty directory: %v", summary["newest"])
+	}
+}
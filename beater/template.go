@@ -0,0 +1,266 @@
+package beater
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultDateFormat is the format of the placeholders naming only a time
+const defaultDateFormat = "%Y%m%d"
+
+// strftimeConversions are the conversions strftime supports
+const strftimeConversions = "YymdHMSjba%"
+
+// target is a remote directory with the file patterns to look for in it
+type target struct {
+	dir   string
+	files []string
+}
+
+// hasPlaceholders tells whether s is a template
+func hasPlaceholders(s string) bool {
+	return strings.Contains(s, "{")
+}
+
+// templateRoot returns the directories of the template before its first
+// placeholder
+func templateRoot(s string) string {
+	i := strings.Index(s, "{")
+	if i < 0 {
+		return s
+	}
+	j := strings.LastIndex(s[:i], "/")
+	if j < 0 {
+		return "."
+	}
+	return s[:j+1]
+}
+
+// targets expands the remote directory and the files for the current day and
+// for every day of the lookback window, the oldest first. The files expanded
+// into the same directory are looked for together.
+func (bt *Ftpbeat) targets(now time.Time) []target {
+	templated := hasPlaceholders(bt.directoryTemplate)
+	for _, file := range bt.fileTemplates {
+		templated = templated || hasPlaceholders(file)
+	}
+	if !templated {
+		return []target{{bt.directoryTemplate, bt.fileTemplates}}
+	}
+
+	// Uploads only go to the directory of the day
+	lookback := bt.lookbackDays
+	if bt.executeType == etPut {
+		lookback = 0
+	}
+
+	var targets []target
+	index := make(map[string]int)
+	now = now.In(bt.location)
+	for day := lookback; day >= 0; day-- {
+		t := now.AddDate(0, 0, -day)
+		dir := expandDate(bt.directoryTemplate, t)
+		i, ok := index[dir]
+		if !ok {
+			i = len(targets)
+			index[dir] = i
+			targets = append(targets, target{dir: dir})
+		}
+		for _, file := range bt.fileTemplates {
+			targets[i].files = appendUnique(targets[i].files, expandDate(file, t))
+		}
+	}
+	return targets
+}
+
+// checkTemplate makes sure every placeholder of s can be expanded, as one
+// kept as is would never match a file
+func checkTemplate(s string) error {
+	for rest := s; ; {
+		i := strings.IndexByte(rest, '{')
+		if i < 0 {
+			return nil
+		}
+		j := strings.IndexByte(rest[i:], '}')
+		if j < 0 {
+			return fmt.Errorf("Unterminated placeholder in [%s]", s)
+		}
+		p := rest[i+1 : i+j]
+		if err := checkPlaceholder(p); err != nil {
+			return fmt.Errorf("Invalid placeholder {%s} in [%s], %v", p, s, err)
+		}
+		rest = rest[i+j+1:]
+	}
+}
+
+// checkPlaceholder checks the contents of a placeholder and its format
+func checkPlaceholder(p string) error {
+	format := p
+	if !strings.HasPrefix(p, "%") {
+		if _, ok := expandPlaceholder(p, time.Now()); !ok {
+			return fmt.Errorf("expected a strftime format or now, today, yesterday or tomorrow with an optional offset and format")
+		}
+		format = defaultDateFormat
+		if i := strings.Index(p, ":"); i >= 0 {
+			format = p[i+1:]
+		}
+	}
+	if format == "" {
+		return fmt.Errorf("the format is empty")
+	}
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		if i == len(format) {
+			return fmt.Errorf("the format ends with a lone %%")
+		}
+		if !strings.Contains(strftimeConversions, format[i:i+1]) {
+			return fmt.Errorf("unknown conversion %%%c, supported: %%Y %%y %%m %%d %%H %%M %%S %%j %%b %%a %%%%", format[i])
+		}
+	}
+	return nil
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, e := range list {
+		if e == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// expandDate replaces the {...} placeholders of s by the time they name. A
+// placeholder is a strftime format of t, like {%Y%m%d}, or names a time
+// relative to t, now, today, yesterday or tomorrow, with an optional offset
+// and format, like {yesterday}, {now-1h:%Y%m%d%H} or {today-1w:%Y/%m/%d}.
+// Unknown placeholders, rejected by checkTemplate, are kept as is.
+func expandDate(s string, t time.Time) string {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '{')
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(s[i:], '}')
+		if j < 0 {
+			break
+		}
+		b.WriteString(s[:i])
+		if expanded, ok := expandPlaceholder(s[i+1:i+j], t); ok {
+			b.WriteString(expanded)
+		} else {
+			b.WriteString(s[i : i+j+1])
+		}
+		s = s[i+j+1:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// expandPlaceholder expands the contents of a placeholder
+func expandPlaceholder(p string, t time.Time) (string, bool) {
+	if strings.HasPrefix(p, "%") {
+		return strftime(p, t), true
+	}
+
+	format := defaultDateFormat
+	if i := strings.Index(p, ":"); i >= 0 {
+		p, format = p[:i], p[i+1:]
+	}
+	i := strings.IndexAny(p, "+-")
+	if i < 0 {
+		i = len(p)
+	}
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch p[:i] {
+	case "now":
+	case "today":
+		t = midnight
+	case "yesterday":
+		t = midnight.AddDate(0, 0, -1)
+	case "tomorrow":
+		t = midnight.AddDate(0, 0, 1)
+	default:
+		return "", false
+	}
+
+	// Offsets like -1h or +2d, possibly several of them
+	for p = p[i:]; p != ""; {
+		j := 1
+		for j < len(p) && p[j] >= '0' && p[j] <= '9' {
+			j++
+		}
+		if (p[0] != '+' && p[0] != '-') || j == 1 || j == len(p) {
+			return "", false
+		}
+		n, err := strconv.Atoi(p[1:j])
+		if err != nil {
+			return "", false
+		}
+		if p[0] == '-' {
+			n = -n
+		}
+		switch p[j] {
+		case 's':
+			t = t.Add(time.Duration(n) * time.Second)
+		case 'm':
+			t = t.Add(time.Duration(n) * time.Minute)
+		case 'h':
+			t = t.Add(time.Duration(n) * time.Hour)
+		case 'd':
+			t = t.AddDate(0, 0, n)
+		case 'w':
+			t = t.AddDate(0, 0, 7*n)
+		default:
+			return "", false
+		}
+		p = p[j+1:]
+	}
+	return strftime(format, t), true
+}
+
+// strftime formats the time with the usual %Y, %m, %d, %H, %M, %S, %y, %j,
+// %b, %a and %% conversions, other characters being kept as is
+func strftime(format string, t time.Time) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'Y':
+			fmt.Fprintf(&b, "%04d", t.Year())
+		case 'y':
+			fmt.Fprintf(&b, "%02d", t.Year()%100)
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 'b':
+			b.WriteString(t.Format("Jan"))
+		case 'a':
+			b.WriteString(t.Format("Mon"))
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(format[i])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,136 @@
+package beater
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStrftime(t *testing.T) {
+	at := time.Date(2026, 3, 7, 5, 4, 9, 0, time.UTC)
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"%Y%m%d", "20260307"},
+		{"%y-%m-%d %H:%M:%S", "26-03-07 05:04:09"},
+		{"%j", "066"},
+		{"%a %d %b", "Sat 07 Mar"},
+		{"100%%", "100%"},
+		{"%Q", "%Q"},
+		{"trailing %", "trailing %"},
+		{"plain", "plain"},
+	}
+	for _, test := range tests {
+		if got := strftime(test.format, at); got != test.want {
+			t.Errorf("strftime(%q) = %q, want %q", test.format, got, test.want)
+		}
+	}
+}
+
+func TestExpandDate(t *testing.T) {
+	at := time.Date(2026, 3, 1, 0, 30, 0, 0, time.UTC)
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"/in/{%Y/%m/%d}/", "/in/2026/03/01/"},
+		{"report_{today}.csv", "report_20260301.csv"},
+		{"report_{yesterday}.csv", "report_20260228.csv"},
+		{"report_{tomorrow:%d.%m.%Y}.csv", "report_02.03.2026.csv"},
+		{"{now-1h:%Y%m%d%H}", "2026022823"},
+		{"{now+90m:%H%M}", "0200"},
+		{"{today-1w}", "20260222"},
+		{"{today-1d+12h:%d %H}", "28 12"},
+		{"{now-30s:%H%M%S}", "002930"},
+		{"{yesterday}_{today}", "20260228_20260301"},
+		{"{YYYYMMDD}", "{YYYYMMDD}"},
+		{"{today-1x}", "{today-1x}"},
+		{"{today-}", "{today-}"},
+		{"no placeholder", "no placeholder"},
+		{"unterminated {today", "unterminated {today"},
+	}
+	for _, test := range tests {
+		if got := expandDate(test.template, at); got != test.want {
+			t.Errorf("expandDate(%q) = %q, want %q", test.template, got, test.want)
+		}
+	}
+}
+
+func TestCheckTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		err      string
+	}{
+		{"/in/{%Y/%m/%d}/", ""},
+		{"report_{yesterday:%y%j}.csv", ""},
+		{"{now-1h:%Y%m%d%H}", ""},
+		{"*.csv", ""},
+		{"{YYYYMMDD}", "Invalid placeholder {YYYYMMDD} in [{YYYYMMDD}], expected a strftime format"},
+		{"{now:}", "Invalid placeholder {now:} in [{now:}], the format is empty"},
+		{"{}", "Invalid placeholder {} in [{}], expected a strftime format"},
+		{"{today-1x}", "Invalid placeholder {today-1x}"},
+		{"{%Y%Q}", "unknown conversion %Q"},
+		{"{today:%Y%}", "the format ends with a lone %"},
+		{"report_{today", "Unterminated placeholder in [report_{today]"},
+	}
+	for _, test := range tests {
+		err := checkTemplate(test.template)
+		if test.err == "" && err != nil {
+			t.Errorf("checkTemplate(%q) = %v", test.template, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("checkTemplate(%q) = %v, want %q", test.template, err, test.err)
+		}
+	}
+}
+
+func TestTemplateRoot(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"/in/{%Y}/{%m}/", "/in/"},
+		{"/in/out", "/in/out"},
+		{"{%Y}", "."},
+		{"in/{today}", "in/"},
+	}
+	for _, test := range tests {
+		if got := templateRoot(test.template); got != test.want {
+			t.Errorf("templateRoot(%q) = %q, want %q", test.template, got, test.want)
+		}
+	}
+}
+
+func TestTargets(t *testing.T) {
+	now := time.Date(2026, 3, 2, 1, 0, 0, 0, time.UTC)
+	tests := []struct {
+		dir      string
+		files    []string
+		lookback int
+		execute  string
+		want     string
+	}{
+		{"/in", []string{"*.csv"}, 2, etGet, "/in:*.csv"},
+		{"/in/{%m}", []string{"{today}.csv"}, 2, etGet,
+			"/in/02:20260228.csv /in/03:20260301.csv,20260302.csv"},
+		{"/in", []string{"{today}.csv", "*.log"}, 1, etGet, "/in:20260301.csv,*.log,20260302.csv"},
+		{"/out/{%d}", []string{"*.csv"}, 3, etPut, "/out/02:*.csv"},
+	}
+	for _, test := range tests {
+		bt := &Ftpbeat{
+			directoryTemplate: test.dir,
+			fileTemplates:     test.files,
+			lookbackDays:      test.lookback,
+			executeType:       test.execute,
+			location:          time.UTC,
+		}
+		var got []string
+		for _, target := range bt.targets(now) {
+			got = append(got, target.dir+":"+strings.Join(target.files, ","))
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("%s %v: targets %v, want %s", test.dir, test.files, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package beater
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// deadlineConn is a net.Conn that pushes its deadline forward before every
+// read and write, so a peer that stops sending for longer than the timeout
+// fails the pending call instead of blocking forever.
+//
+// The deadline is only enforced while the connection is busy: a connection
+// kept open between periods must not time out just because it is idle.
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+
+	mutex sync.Mutex
+	busy  int
+}
+
+func newDeadlineConn(conn net.Conn, timeout time.Duration, always bool) *deadlineConn {
+	c := &deadlineConn{Conn: conn, timeout: timeout}
+	if always {
+		c.busy = 1
+	}
+	return c
+}
+
+func (c *deadlineConn) active() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.timeout > 0 && c.busy > 0
+}
+
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	if c.active() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	if c.active() {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	}
+	return c.Conn.Write(b)
+}
+
+// begin enforces the deadline until the matching end call, including on a
+// read that is already pending.
+func (c *deadlineConn) begin() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.busy++
+	if c.timeout > 0 {
+		c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+}
+
+func (c *deadlineConn) end() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.busy--
+	if c.busy == 0 {
+		c.Conn.SetDeadline(time.Time{})
+	}
+}
+
+// idleReader fails a read once no data arrived for the timeout, using the
+// deadline of the underlying data connection.
+type idleReader struct {
+	io.Reader
+	setDeadline func(time.Time) error
+	timeout     time.Duration
+}
+
+func (r *idleReader) Read(b []byte) (int, error) {
+	if r.timeout > 0 {
+		r.setDeadline(time.Now().Add(r.timeout))
+	}
+	return r.Reader.Read(b)
+}
+
+// isTimeout tells whether err is a network timeout
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// isAborted tells whether err is a transfer cut short, worth retrying even
+// when the session still answers
+func isAborted(err error) bool {
+	return isTimeout(err) || err == io.ErrUnexpectedEOF
+}
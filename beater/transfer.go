@@ -0,0 +1,97 @@
+package beater
+
+import (
+	"sync"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// transfer processes the files over as many sessions as allowed and useful.
+// It returns the names of the files that failed.
+func (bt *Ftpbeat) transfer(files []remoteFile, b *beat.Beat) []string {
+	transfers := bt.maxTransfers
+	if len(files) < transfers {
+		transfers = len(files)
+	}
+	sessions := bt.acquireSessions(transfers)
+	failed := bt.transferFiles(sessions, files, b)
+	bt.failedFiles += len(failed)
+	return failed
+}
+
+// transferFiles spreads files over the given sessions, one worker per session.
+// A file is always handled by a single worker so its events keep their order.
+// It returns the names of the files that failed.
+func (bt *Ftpbeat) transferFiles(sessions []integratedFunc, files []remoteFile, b *beat.Beat) []string {
+	var (
+		wg     sync.WaitGroup
+		mutex  sync.Mutex
+		failed []string
+	)
+
+	queue := make(chan remoteFile)
+	for _, session := range sessions {
+		wg.Add(1)
+		go func(runner integratedFunc) {
+			defer wg.Done()
+			for file := range queue {
+				if err := bt.processFileWithRetries(runner, file, b); err != nil {
+					logp.Err("Processing %s failed: %v", file.Name, err)
+					mutex.Lock()
+					failed = append(failed, file.Name)
+					mutex.Unlock()
+				}
+			}
+		}(session)
+	}
+
+	for _, file := range files {
+		queue <- file
+	}
+	close(queue)
+	wg.Wait()
+
+	return failed
+}
+
+// processFileWithRetries processes a file again when the transfer stalled or
+// the session broke down underneath it. The session is reconnected first as
+// its state is unknown after an aborted transfer.
+func (bt *Ftpbeat) processFileWithRetries(runner integratedFunc, file remoteFile, b *beat.Beat) error {
+	// The file is read again from its start on a retry, the lines already
+	// published are skipped
+	file.published = new(int)
+	err := bt.processFile(runner, file, b)
+	for retry := 1; err != nil && retry <= bt.transferRetries; retry++ {
+		alive := runner.KeepAlive() == nil
+		if alive && !isAborted(err) {
+			return err
+		}
+
+		logp.Warn("Transfer of %s aborted, retrying (%d/%d): %v", file.Name, retry, bt.transferRetries, err)
+		if err = runner.Reconnect(bt); err != nil {
+			continue
+		}
+		err = bt.processFile(runner, file, b)
+	}
+	return err
+}
+
+// processFile reads, gets or puts a single file depending on the execute type
+func (bt *Ftpbeat) processFile(runner integratedFunc, file remoteFile, b *beat.Beat) error {
+	switch bt.executeType {
+	case etRead:
+		return runner.GenEvent(file, bt, b)
+	case etPut:
+		return bt.putFile(runner, file)
+	case etMirror:
+		return bt.mirrorFile(runner, file)
+	}
+
+	err := runner.CopyFiles(file, bt)
+	if err != nil {
+		return err
+	}
+	return runner.GenEventForLocalFile(file, bt, b)
+}
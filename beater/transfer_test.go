@@ -0,0 +1,238 @@
+package beater
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/publisher"
+)
+
+// testClient keeps the events published
+type testClient struct {
+	mutex  sync.Mutex
+	events []common.MapStr
+}
+
+func (c *testClient) Close() error { return nil }
+
+func (c *testClient) PublishEvent(event common.MapStr, opts ...publisher.ClientOption) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.events = append(c.events, event)
+	return true
+}
+
+func (c *testClient) PublishEvents(events []common.MapStr, opts ...publisher.ClientOption) bool {
+	for _, event := range events {
+		c.PublishEvent(event)
+	}
+	return true
+}
+
+func (c *testClient) messages() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var messages []string
+	for _, event := range c.events {
+		messages = append(messages, event["message"].(string))
+	}
+	return messages
+}
+
+// abortingRunner reads the contents of a file, the first reads being cut
+// short after some lines
+type abortingRunner struct {
+	integratedFunc
+	contents string
+	// aborts are the numbers of lines of the aborted reads
+	aborts []int
+}
+
+func (r *abortingRunner) KeepAlive() error            { return nil }
+func (r *abortingRunner) Reconnect(bt *Ftpbeat) error { return nil }
+
+func (r *abortingRunner) GenEvent(file remoteFile, bt *Ftpbeat, b *beat.Beat) error {
+	if len(r.aborts) == 0 {
+		return publishLines(bt, file, strings.NewReader(r.contents))
+	}
+	lines := strings.SplitAfter(r.contents, "\n")[:r.aborts[0]]
+	r.aborts = r.aborts[1:]
+	if err := publishLines(bt, file, strings.NewReader(strings.Join(lines, ""))); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+func TestProcessFileWithRetriesResumes(t *testing.T) {
+	tests := []struct {
+		name    string
+		aborts  []int
+		retries int
+		want    string
+		fails   bool
+	}{
+		{"no abort", nil, 2, "l1 l2 l3 l4", false},
+		{"abort once", []int{2}, 2, "l1 l2 l3 l4", false},
+		{"abort twice", []int{1, 3}, 2, "l1 l2 l3 l4", false},
+		{"abort before any line", []int{0}, 1, "l1 l2 l3 l4", false},
+		{"out of retries", []int{1, 2}, 1, "l1 l2", true},
+	}
+	for _, test := range tests {
+		client := &testClient{}
+		bt := &Ftpbeat{executeType: etRead, transferRetries: test.retries, client: client}
+		runner := &abortingRunner{contents: "l1\nl2\nl3\nl4\n", aborts: test.aborts}
+
+		err := bt.processFileWithRetries(runner, remoteFile{Name: "a.log"}, nil)
+		if (err != nil) != test.fails {
+			t.Errorf("%s: processFileWithRetries() = %v", test.name, err)
+		}
+		if got := strings.Join(client.messages(), " "); got != test.want {
+			t.Errorf("%s: published %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+// poolRunner is a session of a pool. It publishes three lines per file,
+// letting the other sessions run in between, and fails the files named bad.
+type poolRunner struct {
+	integratedFunc
+	pool *pool
+	id   int
+	dead bool
+}
+
+// pool keeps track of the sessions opened and of the files they processed
+type pool struct {
+	mutex     sync.Mutex
+	sessions  int
+	failOpens bool
+	processed map[int][]string
+}
+
+func (p *pool) newRunner() *poolRunner {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.sessions++
+	return &poolRunner{pool: p, id: p.sessions}
+}
+
+func (r *poolRunner) Session(bt *Ftpbeat) (integratedFunc, error) {
+	if r.pool.failOpens {
+		return nil, fmt.Errorf("too many connections")
+	}
+	return r.pool.newRunner(), nil
+}
+
+func (r *poolRunner) KeepAlive() error {
+	if r.dead {
+		return fmt.Errorf("connection reset")
+	}
+	return nil
+}
+
+func (r *poolRunner) Reconnect(bt *Ftpbeat) error { return nil }
+func (r *poolRunner) Quit()                       { r.dead = true }
+
+func (r *poolRunner) GenEvent(file remoteFile, bt *Ftpbeat, b *beat.Beat) error {
+	r.pool.mutex.Lock()
+	r.pool.processed[r.id] = append(r.pool.processed[r.id], file.Name)
+	r.pool.mutex.Unlock()
+	if strings.HasPrefix(file.Name, "bad") {
+		return fmt.Errorf("550 %s: permission denied", file.Name)
+	}
+	for i := 1; i <= 3; i++ {
+		bt.client.PublishEvent(newEvent(bt, file, fmt.Sprintf("%s:%d", file.Name, i)))
+		time.Sleep(time.Millisecond)
+	}
+	return nil
+}
+
+func TestTransferPool(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxTransfers int
+		files        []string
+		failOpens    bool
+		sessions     int
+		failed       string
+	}{
+		{"one session", 1, []string{"a", "b", "c"}, false, 1, ""},
+		{"bounded by the max", 3, []string{"a", "b", "c", "d", "e", "f"}, false, 3, ""},
+		{"bounded by the files", 4, []string{"a", "b"}, false, 2, ""},
+		{"no additional session", 3, []string{"a", "b", "c"}, true, 1, ""},
+		{"failed files", 2, []string{"a", "bad1", "b", "bad2", "c"}, false, 2, "bad1 bad2"},
+	}
+	for _, test := range tests {
+		client := &testClient{}
+		p := &pool{failOpens: test.failOpens, processed: make(map[int][]string)}
+		bt := &Ftpbeat{executeType: etRead, maxTransfers: test.maxTransfers, client: client, runner: p.newRunner()}
+		var files []remoteFile
+		for _, name := range test.files {
+			files = append(files, remoteFile{Name: name})
+		}
+
+		failed := bt.transfer(files, nil)
+		sort.Strings(failed)
+		if got := strings.Join(failed, " "); got != test.failed {
+			t.Errorf("%s: failed %q, want %q", test.name, got, test.failed)
+		}
+		if bt.failedFiles != len(failed) {
+			t.Errorf("%s: %d failed files counted", test.name, bt.failedFiles)
+		}
+		if len(p.processed) != test.sessions || p.sessions != test.sessions {
+			t.Errorf("%s: %d sessions used, %d opened, want %d", test.name, len(p.processed), p.sessions, test.sessions)
+		}
+
+		// Every file is processed once, its lines in order
+		var processed []string
+		for _, names := range p.processed {
+			processed = append(processed, names...)
+		}
+		if len(processed) != len(test.files) {
+			t.Errorf("%s: processed %v", test.name, processed)
+		}
+		lines := make(map[string][]string)
+		for _, message := range client.messages() {
+			name := strings.Split(message, ":")[0]
+			lines[name] = append(lines[name], message)
+		}
+		for _, name := range test.files {
+			want := fmt.Sprintf("%s:1 %s:2 %s:3", name, name, name)
+			if strings.HasPrefix(name, "bad") {
+				want = ""
+			}
+			if got := strings.Join(lines[name], " "); got != want {
+				t.Errorf("%s: %s published %q, want %q", test.name, name, got, want)
+			}
+		}
+	}
+}
+
+// The sessions kept from the previous period are reused, the dead ones
+// replaced
+func TestAcquireSessions(t *testing.T) {
+	p := &pool{processed: make(map[int][]string)}
+	bt := &Ftpbeat{runner: p.newRunner()}
+
+	sessions := bt.acquireSessions(3)
+	if len(sessions) != 3 || sessions[0] != bt.runner || p.sessions != 3 {
+		t.Fatalf("%d sessions, %d opened, want 3", len(sessions), p.sessions)
+	}
+
+	sessions[1].Quit()
+	again := bt.acquireSessions(3)
+	if len(again) != 3 || again[1] != sessions[2] || again[2] == sessions[1] || p.sessions != 4 {
+		t.Errorf("the dead session was not the only one replaced, %d opened", p.sessions)
+	}
+
+	if fewer := bt.acquireSessions(2); len(fewer) != 2 || fewer[1] != again[1] || p.sessions != 4 {
+		t.Errorf("%d sessions when asking for 2", len(fewer))
+	}
+}
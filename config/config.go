@@ -3,19 +3,233 @@
 
 package config
 
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/outputs"
+)
+
 type Config struct {
-	Ftpbeat FtpbeatConfig
+	Ftpbeat FtpbeatConfig `config:"ftpbeat"`
 }
 
 type FtpbeatConfig struct {
-	Period           string   `yaml:"period"`
-	ConnectType      string   `yaml:"connecttype"`
-	Hostname         string   `yaml:"hostname"`
-	Port             string   `yaml:"port"`
-	Username         string   `yaml:"username"`
-	Password         string   `yaml:"password"`
-	RemoteDirectory  string   `yaml:"remotedirectory"`
-	CurrentDirectory string   `yaml:"currentdirectory"`
-	Files            []string `yaml:"files"`
-	ExecuteType      string   `yaml:"executetype"`
+	Period                 time.Duration        `config:"period" validate:"positive"`
+	ConnectType            string               `config:"connecttype"`
+	Hostname               string               `config:"hostname"`
+	Port                   int                  `config:"port"`
+	Username               string               `config:"username"`
+	Password               string               `config:"password"`
+	PasswordFile           string               `config:"password_file"`
+	RemoteDirectory        string               `config:"remotedirectory"`
+	CurrentDirectory       string               `config:"currentdirectory"`
+	Files                  []string             `config:"files"`
+	ExecuteType            string               `config:"executetype"`
+	MaxConcurrentTransfers int                  `config:"max_concurrent_transfers" validate:"positive"`
+	IdleTimeout            *time.Duration       `config:"idle_timeout" validate:"positive"`
+	Timeouts               TimeoutsConfig       `config:"timeouts"`
+	TransferRetries        *int                 `config:"transfer_retries" validate:"positive"`
+	RateLimit              int64                `config:"rate_limit" validate:"positive"`
+	RateLimitSchedule      []RateLimitWindow    `config:"rate_limit_schedule"`
+	GlobalRateLimit        int64                `config:"global_rate_limit" validate:"positive"`
+	FTP                    FTPConfig            `config:"ftp"`
+	SCP                    SCPConfig            `config:"scp"`
+	SSH                    SSHConfig            `config:"ssh"`
+	ProxyURL               string               `config:"proxy_url"`
+	S3                     S3Config             `config:"s3"`
+	HTTP                   HTTPConfig           `config:"http"`
+	Put                    PutConfig            `config:"put"`
+	Mirror                 MirrorConfig         `config:"mirror"`
+	Expectations           []ExpectationConfig  `config:"expectations"`
+	Schedule               string               `config:"schedule"`
+	ActiveWindows          []ActiveWindowConfig `config:"active_windows"`
+	Jitter                 time.Duration        `config:"jitter" validate:"positive"`
+	RunOnce                bool                 `config:"run_once"`
+	Timezone               string               `config:"timezone"`
+	LookbackDays           int                  `config:"lookback_days" validate:"positive"`
+	IncludeFiles           []string             `config:"include_files"`
+	ExcludeFiles           []string             `config:"exclude_files"`
+	CaseInsensitive        bool                 `config:"case_insensitive"`
+	MinSize                int64                `config:"min_size" validate:"positive"`
+	MaxSize                int64                `config:"max_size" validate:"positive"`
+	OrderBy                string               `config:"order_by"`
+	Order                  string               `config:"order"`
+	MaxFilesPerRun         int                  `config:"max_files_per_run" validate:"positive"`
+}
+
+type TimeoutsConfig struct {
+	Connect  time.Duration `config:"connect" validate:"positive"`
+	Command  time.Duration `config:"command" validate:"positive"`
+	IdleRead time.Duration `config:"idle_read" validate:"positive"`
+}
+
+type RateLimitWindow struct {
+	From      string `config:"from"`
+	To        string `config:"to"`
+	RateLimit int64  `config:"rate_limit" validate:"positive"`
+}
+
+type FTPConfig struct {
+	Mode            string `config:"mode"`
+	ActiveAddress   string `config:"active_address"`
+	ActivePortRange string `config:"active_port_range"`
+}
+
+type SCPConfig struct {
+	ListCommand string `config:"list_command"`
+}
+
+type SSHConfig struct {
+	KeyFile    string         `config:"key_file"`
+	KnownHosts string         `config:"known_hosts"`
+	HostKey    string         `config:"host_key"`
+	ProxyJump  []SSHHopConfig `config:"proxy_jump"`
+}
+
+type SSHHopConfig struct {
+	Host         string `config:"host"`
+	Port         int    `config:"port"`
+	Username     string `config:"username"`
+	Password     string `config:"password"`
+	PasswordFile string `config:"password_file"`
+	KeyFile      string `config:"key_file"`
+	KnownHosts   string `config:"known_hosts"`
+	HostKey      string `config:"host_key"`
+}
+
+type S3Config struct {
+	Endpoint        string `config:"endpoint"`
+	Bucket          string `config:"bucket"`
+	Prefix          string `config:"prefix"`
+	Region          string `config:"region"`
+	AccessKeyID     string `config:"access_key_id"`
+	SecretAccessKey string `config:"secret_access_key"`
+	SessionToken    string `config:"session_token"`
+	PathStyle       bool   `config:"path_style"`
+	After           string `config:"after"`
+	MoveTo          string `config:"move_to"`
+}
+
+type HTTPConfig struct {
+	URL         string             `config:"url"`
+	Auth        string             `config:"auth"`
+	BearerToken string             `config:"bearer_token"`
+	TLS         *outputs.TLSConfig `config:"ssl"`
+}
+
+type PutConfig struct {
+	After      string `config:"after"`
+	MoveTo     string `config:"move_to"`
+	TempSuffix string `config:"temp_suffix"`
+	Event      bool   `config:"event"`
+}
+
+type MirrorConfig struct {
+	Delete               bool `config:"delete"`
+	DeleteOnEmptyListing bool `config:"delete_on_empty_listing"`
+}
+
+type ExpectationConfig struct {
+	Name         string   `config:"name"`
+	File         string   `config:"file"`
+	Deadline     string   `config:"deadline"`
+	Timezone     string   `config:"timezone"`
+	DateOffset   int      `config:"date_offset"`
+	BusinessDays []string `config:"business_days"`
+	Holidays     []string `config:"holidays"`
+}
+
+type ActiveWindowConfig struct {
+	From     string   `config:"from"`
+	To       string   `config:"to"`
+	Days     []string `config:"days"`
+	Timezone string   `config:"timezone"`
+}
+
+// Validate checks the supported values and the settings which depend on each
+// other, reporting all the errors at once. Empty settings are left to their
+// defaults. The settings which must not be negative are checked by their
+// validate tags as they are unpacked.
+func (c *FtpbeatConfig) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(oneOf(c.ConnectType, "ftp", "sftp", "scp", "local", "s3", "webdav", "http-index"),
+		"Unknown [%s] Connection type, supported types: `ftp`, `sftp`, `scp`, `local`, `s3`, `webdav`, `http-index`", c.ConnectType)
+	check(oneOf(c.ExecuteType, "read", "get", "put", "mirror", "stat"),
+		"Unknown [%s] Execute type, supported types: `read`, `get`, `put`, `mirror`, `stat`", c.ExecuteType)
+	check(oneOf(c.FTP.Mode, "passive", "active"),
+		"Unknown [%s] FTP mode, supported modes: `passive`, `active`", c.FTP.Mode)
+	check(oneOf(c.OrderBy, "name", "mtime", "size"),
+		"Unknown [%s] Order by, supported orders: `name`, `mtime`, `size`", c.OrderBy)
+	check(oneOf(c.Order, "asc", "desc"),
+		"Unknown [%s] Order, supported orders: `asc`, `desc`", c.Order)
+	check(oneOf(c.Put.After, "move", "delete"),
+		"Unknown [%s] Put after action, supported actions: `move`, `delete`", c.Put.After)
+	check(oneOf(c.S3.After, "move", "delete"),
+		"Unknown [%s] S3 after action, supported actions: `move`, `delete`", c.S3.After)
+	check(oneOf(c.HTTP.Auth, "none", "basic", "bearer"),
+		"Unknown [%s] HTTP auth, supported auths: `none`, `basic`, `bearer`", c.HTTP.Auth)
+
+	// The min and max validate tags would report a port out of range with
+	// the bounds reversed
+	check(validPort(c.Port), "Invalid [%d] Port, supported ports: 1 to 65535, or 0 for the default port", c.Port)
+
+	check(len(c.Files) > 0 || len(c.IncludeFiles) > 0, "There are no files to get")
+	check(c.MaxSize == 0 || c.MinSize <= c.MaxSize, "Min size %d is over max size %d", c.MinSize, c.MaxSize)
+	check(!c.Mirror.Delete || c.CurrentDirectory != "",
+		"Mirror delete needs the currentdirectory to be set to the directory of the copy")
+	check(c.Password == "" || c.PasswordFile == "", "Password and password file cannot both be set")
+	switch c.ConnectType {
+	case "s3":
+		check(c.S3.Bucket != "", "There is no S3 bucket")
+		check(c.S3.After != "move" || c.S3.MoveTo != "", "There is no S3 prefix to move the objects to")
+		check(c.S3.After == "" || oneOf(c.ExecuteType, "read", "get"),
+			"S3 after action [%s] only applies to the `read` and `get` Execute types", c.S3.After)
+	case "webdav", "http-index":
+		check(c.HTTP.URL != "", "There is no URL of the directory")
+		check(c.HTTP.Auth != "bearer" || c.HTTP.BearerToken != "", "There is no bearer token")
+	case "", "ftp":
+		check(c.ProxyURL == "" || c.FTP.Mode != "active", "FTP active mode cannot be used through a proxy")
+	}
+	for index, hop := range c.SSH.ProxyJump {
+		check(hop.Host != "", "Jump host #%d has no host", index+1)
+		check(validPort(hop.Port), "Invalid [%d] Port of jump host #%d, supported ports: 1 to 65535, or 0 for 22", hop.Port, index+1)
+		check(hop.KnownHosts != "" || hop.HostKey != "",
+			"Jump host #%d does not verify its host key, set known_hosts or host_key", index+1)
+	}
+	check(len(c.SSH.ProxyJump) == 0 || c.SSH.KnownHosts != "" || c.SSH.HostKey != "",
+		"The server reached through jump hosts does not verify its host key, set ssh.known_hosts or ssh.host_key")
+
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("%s", errs[0])
+	}
+	return fmt.Errorf("%d config errors:\n  %s", len(errs), strings.Join(errs, "\n  "))
+}
+
+// oneOf tells whether the value is empty or one of the supported values
+func oneOf(value string, supported ...string) bool {
+	if value == "" {
+		return true
+	}
+	for _, s := range supported {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validPort tells whether the port is a TCP port or 0 for the default one
+func validPort(port int) bool {
+	return port >= 0 && port <= 65535
 }
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestValidate(t *testing.T) {
+	valid := func() FtpbeatConfig {
+		return FtpbeatConfig{Files: []string{"*.csv"}}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *FtpbeatConfig)
+		err    string
+	}{
+		{"defaults", func(c *FtpbeatConfig) {}, ""},
+		{"no files", func(c *FtpbeatConfig) { c.Files = nil }, "There are no files to get"},
+		{"include files only", func(c *FtpbeatConfig) { c.Files, c.IncludeFiles = nil, []string{`\.csv$`} }, ""},
+		{"unknown connect type", func(c *FtpbeatConfig) { c.ConnectType = "nfs" }, "Unknown [nfs] Connection type"},
+		{"unknown execute type", func(c *FtpbeatConfig) { c.ExecuteType = "copy" }, "Unknown [copy] Execute type"},
+		{"unknown order", func(c *FtpbeatConfig) { c.Order = "random" }, "Unknown [random] Order"},
+		{"port", func(c *FtpbeatConfig) { c.Port = 2121 }, ""},
+		{"default port", func(c *FtpbeatConfig) { c.Port = 0 }, ""},
+		{"port out of range", func(c *FtpbeatConfig) { c.Port = 70000 }, "Invalid [70000] Port, supported ports: 1 to 65535, or 0 for the default port"},
+		{"negative port", func(c *FtpbeatConfig) { c.Port = -21 }, "Invalid [-21] Port"},
+		{"min size over max size", func(c *FtpbeatConfig) { c.MinSize, c.MaxSize = 100, 10 }, "Min size 100 is over max size 10"},
+		{"min size without max size", func(c *FtpbeatConfig) { c.MinSize = 100 }, ""},
+		{"password and password file", func(c *FtpbeatConfig) { c.Password, c.PasswordFile = "secret", "/etc/secret" },
+			"Password and password file cannot both be set"},
+		{"s3 without bucket", func(c *FtpbeatConfig) { c.ConnectType = "s3" }, "There is no S3 bucket"},
+		{"s3 move without prefix", func(c *FtpbeatConfig) {
+			c.ConnectType, c.S3.Bucket, c.S3.After = "s3", "drop", "move"
+		}, "There is no S3 prefix to move the objects to"},
+		{"s3 after with put", func(c *FtpbeatConfig) {
+			c.ConnectType, c.ExecuteType, c.S3.Bucket, c.S3.After = "s3", "put", "drop", "delete"
+		}, "S3 after action [delete] only applies"},
+		{"webdav without url", func(c *FtpbeatConfig) { c.ConnectType = "webdav" }, "There is no URL of the directory"},
+		{"active mode through a proxy", func(c *FtpbeatConfig) { c.FTP.Mode, c.ProxyURL = "active", "socks5://proxy:1080" },
+			"FTP active mode cannot be used through a proxy"},
+		{"mirror delete without current directory", func(c *FtpbeatConfig) { c.ExecuteType, c.Mirror.Delete = "mirror", true },
+			"Mirror delete needs the currentdirectory"},
+		{"mirror delete", func(c *FtpbeatConfig) {
+			c.ExecuteType, c.Mirror.Delete, c.CurrentDirectory = "mirror", true, "/data/mirror"
+		}, ""},
+		{"jump host without host key", func(c *FtpbeatConfig) {
+			c.SSH.KnownHosts = "/etc/ssh/known_hosts"
+			c.SSH.ProxyJump = []SSHHopConfig{{Host: "bastion"}}
+		}, "Jump host #1 does not verify its host key"},
+		{"server behind jump hosts without host key", func(c *FtpbeatConfig) {
+			c.SSH.ProxyJump = []SSHHopConfig{{Host: "bastion", HostKey: "SHA256:abc"}}
+		}, "The server reached through jump hosts does not verify its host key"},
+		{"jump host port out of range", func(c *FtpbeatConfig) {
+			c.SSH.KnownHosts = "/etc/ssh/known_hosts"
+			c.SSH.ProxyJump = []SSHHopConfig{{Host: "bastion", Port: 65536, HostKey: "SHA256:abc"}}
+		}, "Invalid [65536] Port of jump host #1"},
+		{"jump hosts", func(c *FtpbeatConfig) {
+			c.SSH.KnownHosts = "/etc/ssh/known_hosts"
+			c.SSH.ProxyJump = []SSHHopConfig{{Host: "bastion", KnownHosts: "/etc/ssh/known_hosts"}}
+		}, ""},
+		{"several errors", func(c *FtpbeatConfig) { c.Files, c.ConnectType = nil, "nfs" }, "2 config errors:"},
+	}
+	for _, test := range tests {
+		c := valid()
+		test.modify(&c)
+		err := c.Validate()
+		if test.err == "" && err != nil {
+			t.Errorf("%s: Validate() = %v", test.name, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: Validate() = %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+// The ranges of single settings are checked by their validate tags
+func TestUnpack(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		err      string
+	}{
+		{"defaults", nil, ""},
+		{"negative period", map[string]interface{}{"period": "-1s"}, "negative value accessing 'ftpbeat.period'"},
+		{"negative timeout", map[string]interface{}{"timeouts.idle_read": "-1m"}, "negative value accessing 'ftpbeat.timeouts.idle_read'"},
+		{"negative idle timeout", map[string]interface{}{"idle_timeout": "-1s"}, "negative value accessing 'ftpbeat.idle_timeout'"},
+		{"no idle timeout", map[string]interface{}{"idle_timeout": "0"}, ""},
+		{"negative retries", map[string]interface{}{"transfer_retries": -1}, "negative value accessing 'ftpbeat.transfer_retries'"},
+		{"negative rate limit", map[string]interface{}{"rate_limit": -1}, "negative value accessing 'ftpbeat.rate_limit'"},
+		{"negative window rate limit", map[string]interface{}{
+			"rate_limit_schedule": []map[string]interface{}{{"from": "08:00", "to": "18:00", "rate_limit": -5}},
+		}, "negative value accessing 'ftpbeat.rate_limit_schedule.0.rate_limit'"},
+		{"negative min size", map[string]interface{}{"min_size": -1}, "negative value accessing 'ftpbeat.min_size'"},
+		{"port out of range", map[string]interface{}{"port": 70000}, "Invalid [70000] Port"},
+		{"checked once unpacked", map[string]interface{}{"connecttype": "nfs"}, "Unknown [nfs] Connection type"},
+	}
+	for _, test := range tests {
+		settings := map[string]interface{}{"files": []string{"*.csv"}}
+		for key, value := range test.settings {
+			settings[key] = value
+		}
+		cfg, err := common.NewConfigFrom(map[string]interface{}{"ftpbeat": settings})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var c Config
+		err = cfg.Unpack(&c)
+		if test.err == "" && err != nil {
+			t.Errorf("%s: Unpack() = %v", test.name, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: Unpack() = %v, want %q", test.name, err, test.err)
+		}
+	}
+}
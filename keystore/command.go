@@ -0,0 +1,162 @@
+package keystore
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var keystorePath = flag.String("keystore.path", "", "Keystore file, "+FileName+" of the config directory by default")
+
+// validName is what a secret can be named, to be referenced as ${NAME}
+var validName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+const usage = `Usage: ftpbeat keystore [-path file] <command> [arguments]
+
+Commands:
+  add <name> [-stdin] [-force]   Add a secret, read from the terminal or stdin
+  list                           List the names of the secrets
+  remove <name>                  Remove a secret
+
+The secrets are referenced in the config as ${name}. The keystore is
+encrypted with the password in the ` + PasswordEnv + ` environment variable.
+`
+
+// Command runs the keystore command line and returns the exit status
+func Command(args []string) int {
+	if err := command(args, os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func command(args []string, stdin *os.File, stdout io.Writer) error {
+	flags := flag.NewFlagSet("keystore", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	path := flags.String("path", DefaultPath(), "Keystore file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return fmt.Errorf("No keystore command given")
+	}
+
+	ks, err := Open(*path, os.Getenv(PasswordEnv))
+	if err != nil {
+		return err
+	}
+
+	switch cmd, args := flags.Arg(0), flags.Args()[1:]; cmd {
+	case "add":
+		addFlags := flag.NewFlagSet("keystore add", flag.ContinueOnError)
+		fromStdin := addFlags.Bool("stdin", false, "Read the secret from stdin")
+		force := addFlags.Bool("force", false, "Replace an existing secret")
+		name, args := splitName(args)
+		if err := addFlags.Parse(args); err != nil {
+			return err
+		}
+		if name == "" {
+			name = addFlags.Arg(0)
+		}
+		if !validName.MatchString(name) {
+			return fmt.Errorf("Invalid secret name [%s]", name)
+		}
+		if _, ok := ks.Get(name); ok && !*force {
+			return fmt.Errorf("Secret %s already exists, use -force to replace it", name)
+		}
+		value, err := readSecret(name, stdin, stdout, *fromStdin)
+		if err != nil {
+			return err
+		}
+		ks.Set(name, value)
+		if err := ks.Save(); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Added %s to %s\n", name, *path)
+
+	case "list":
+		for _, name := range ks.Names() {
+			fmt.Fprintln(stdout, name)
+		}
+
+	case "remove":
+		if len(args) != 1 {
+			return fmt.Errorf("Usage: ftpbeat keystore remove <name>")
+		}
+		if !ks.Remove(args[0]) {
+			return fmt.Errorf("Secret %s not found in %s", args[0], *path)
+		}
+		if err := ks.Save(); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Removed %s from %s\n", args[0], *path)
+
+	default:
+		flags.Usage()
+		return fmt.Errorf("Unknown [%s] keystore command", cmd)
+	}
+	return nil
+}
+
+// splitName takes the name given before the flags of a command
+func splitName(args []string) (string, []string) {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return args[0], args[1:]
+	}
+	return "", args
+}
+
+// readSecret reads the secret without echoing it from a terminal, or as the
+// first line of stdin
+func readSecret(name string, stdin *os.File, stdout io.Writer, fromStdin bool) (string, error) {
+	if !fromStdin && terminal.IsTerminal(int(stdin.Fd())) {
+		fmt.Fprintf(stdout, "Enter value for %s: ", name)
+		value, err := terminal.ReadPassword(int(stdin.Fd()))
+		fmt.Fprintln(stdout)
+		return string(value), err
+	}
+	value, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(value, "\r\n"), nil
+}
+
+// Export makes the secrets of the keystore available to the config as
+// ${name}, like environment variables, which take precedence. It runs once
+// the flags are parsed, before the config is read.
+func Export(b *beat.Beat) error {
+	path := *keystorePath
+	if path == "" {
+		path = DefaultPath()
+	}
+	return ExportFile(path)
+}
+
+// ExportFile makes the secrets of the keystore at path available to the
+// config, for the commands run without the beat
+func ExportFile(path string) error {
+	ks, err := Open(path, os.Getenv(PasswordEnv))
+	if err != nil {
+		return err
+	}
+	for _, name := range ks.Names() {
+		if _, ok := os.LookupEnv(name); ok {
+			continue
+		}
+		value, _ := ks.Get(name)
+		if err := os.Setenv(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
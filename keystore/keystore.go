@@ -0,0 +1,187 @@
+// Package keystore keeps the secrets referenced by the config in a file
+// encrypted with AES-GCM, under a key derived from a password with scrypt.
+// The password is taken from the environment and may be empty, the secrets
+// then only being obfuscated.
+
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// FileName is the name of the keystore file in the config directory
+	FileName = "ftpbeat.keystore"
+	// PasswordEnv is the environment variable holding the keystore password
+	PasswordEnv = "FTPBEAT_KEYSTORE_PASSWORD"
+
+	version = 1
+)
+
+// Keystore holds the secrets by name
+type Keystore struct {
+	path     string
+	password string
+	secrets  map[string]string
+	exists   bool
+}
+
+// file is the format of the keystore file
+type file struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// DefaultPath returns the keystore file of the config directory, set by the
+// -path.config or -path.home flags, the directory of the binary otherwise
+func DefaultPath() string {
+	for _, name := range []string{"path.config", "path.home"} {
+		if f := flag.Lookup(name); f != nil && f.Value.String() != "" {
+			return filepath.Join(f.Value.String(), FileName)
+		}
+	}
+	if dir, err := filepath.Abs(filepath.Dir(os.Args[0])); err == nil {
+		return filepath.Join(dir, FileName)
+	}
+	return FileName
+}
+
+// Open reads the keystore at path. A missing file is an empty keystore,
+// created when saved.
+func Open(path, password string) (*Keystore, error) {
+	ks := &Keystore{path: path, password: password, secrets: make(map[string]string)}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f file
+	if err := json.Unmarshal(content, &f); err != nil {
+		return nil, fmt.Errorf("Invalid keystore %s: %v", path, err)
+	}
+	if f.Version != version {
+		return nil, fmt.Errorf("Unsupported version %d of keystore %s", f.Version, path)
+	}
+	aead, err := newAEAD(password, f.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, f.Nonce, f.Data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Could not decrypt keystore %s, wrong password?", path)
+	}
+	if err := json.Unmarshal(plain, &ks.secrets); err != nil {
+		return nil, fmt.Errorf("Invalid keystore %s: %v", path, err)
+	}
+	ks.exists = true
+	return ks, nil
+}
+
+// Exists tells whether the keystore file was there
+func (ks *Keystore) Exists() bool {
+	return ks.exists
+}
+
+// Get returns the secret
+func (ks *Keystore) Get(name string) (string, bool) {
+	value, ok := ks.secrets[name]
+	return value, ok
+}
+
+// Set adds or replaces a secret
+func (ks *Keystore) Set(name, value string) {
+	ks.secrets[name] = value
+}
+
+// Remove removes a secret, telling whether it was there
+func (ks *Keystore) Remove(name string) bool {
+	_, ok := ks.secrets[name]
+	delete(ks.secrets, name)
+	return ok
+}
+
+// Names returns the sorted names of the secrets
+func (ks *Keystore) Names() []string {
+	var names []string
+	for name := range ks.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Save encrypts the secrets under a new salt and writes the file, readable
+// by its owner only, replacing the previous one at once
+func (ks *Keystore) Save() error {
+	plain, err := json.Marshal(ks.secrets)
+	if err != nil {
+		return err
+	}
+	f := file{Version: version, Salt: make([]byte, 16)}
+	if _, err := rand.Read(f.Salt); err != nil {
+		return err
+	}
+	aead, err := newAEAD(ks.password, f.Salt)
+	if err != nil {
+		return err
+	}
+	f.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(f.Nonce); err != nil {
+		return err
+	}
+	f.Data = aead.Seal(nil, f.Nonce, plain, nil)
+
+	content, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(ks.path), ".keystore")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(content)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0600)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), ks.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	ks.exists = true
+	return nil
+}
+
+// newAEAD derives the key from the password and the salt
+func newAEAD(password string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(password), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
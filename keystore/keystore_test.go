@@ -0,0 +1,131 @@
+package keystore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func tempKeystore(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, FileName)
+}
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	for _, password := range []string{"", "s3cret"} {
+		path := tempKeystore(t)
+
+		ks, err := Open(path, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ks.Exists() {
+			t.Errorf("password %q: a missing keystore exists", password)
+		}
+		ks.Set("FTP_PASSWORD", "p@ss word\n")
+		ks.Set("S3_SECRET", "wJalrXUtnFEMI")
+		ks.Set("REMOVED", "x")
+		if !ks.Remove("REMOVED") || ks.Remove("REMOVED") {
+			t.Errorf("password %q: Remove() did not tell whether the secret was there", password)
+		}
+		if err := ks.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("password %q: keystore mode %v, want 0600", password, info.Mode().Perm())
+		}
+		content, _ := ioutil.ReadFile(path)
+		if strings.Contains(string(content), "wJalrXUtnFEMI") {
+			t.Errorf("password %q: secret saved in clear", password)
+		}
+
+		ks, err = Open(path, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ks.Exists() {
+			t.Errorf("password %q: the saved keystore does not exist", password)
+		}
+		if got := strings.Join(ks.Names(), " "); got != "FTP_PASSWORD S3_SECRET" {
+			t.Errorf("password %q: Names() = %q", password, got)
+		}
+		if value, ok := ks.Get("FTP_PASSWORD"); !ok || value != "p@ss word\n" {
+			t.Errorf("password %q: Get() = %q, %v", password, value, ok)
+		}
+		if _, ok := ks.Get("REMOVED"); ok {
+			t.Errorf("password %q: removed secret saved", password)
+		}
+	}
+}
+
+func TestKeystoreOpenErrors(t *testing.T) {
+	path := tempKeystore(t)
+	ks, _ := Open(path, "right")
+	ks.Set("NAME", "value")
+	if err := ks.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		content  string
+		password string
+		err      string
+	}{
+		{"wrong password", "", "wrong", "wrong password?"},
+		{"no password", "", "", "wrong password?"},
+		{"not json", "secrets", "right", "Invalid keystore"},
+		{"other version", `{"version":2}`, "right", "Unsupported version 2"},
+		{"truncated data", `{"version":1,"salt":"AAAA","nonce":"AAAAAAAAAAAAAAAA","data":"AAAA"}`, "right", "wrong password?"},
+	}
+	for _, test := range tests {
+		p := path
+		if test.content != "" {
+			p = filepath.Join(filepath.Dir(path), "other.keystore")
+			if err := ioutil.WriteFile(p, []byte(test.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err := Open(p, test.password)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: Open() = %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestExportFile(t *testing.T) {
+	path := tempKeystore(t)
+	os.Setenv(PasswordEnv, "pw")
+	defer os.Unsetenv(PasswordEnv)
+	ks, _ := Open(path, "pw")
+	ks.Set("FTPBEAT_TEST_FROM_KEYSTORE", "keystore")
+	ks.Set("FTPBEAT_TEST_FROM_ENV", "keystore")
+	if err := ks.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The environment takes precedence
+	os.Setenv("FTPBEAT_TEST_FROM_ENV", "env")
+	defer os.Unsetenv("FTPBEAT_TEST_FROM_ENV")
+	defer os.Unsetenv("FTPBEAT_TEST_FROM_KEYSTORE")
+	if err := ExportFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := os.Getenv("FTPBEAT_TEST_FROM_KEYSTORE"); got != "keystore" {
+		t.Errorf("secret exported as %q", got)
+	}
+	if got := os.Getenv("FTPBEAT_TEST_FROM_ENV"); got != "env" {
+		t.Errorf("environment variable replaced by %q", got)
+	}
+}
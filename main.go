@@ -6,9 +6,20 @@ import (
 	"github.com/elastic/beats/libbeat/beat"
 
 	"github.com/affinity226/ftpbeat/beater"
+	"github.com/affinity226/ftpbeat/keystore"
 )
 
 func main() {
+	// The keystore is managed and the connection tested without running the
+	// beat
+	if len(os.Args) > 1 && os.Args[1] == "keystore" {
+		os.Exit(keystore.Command(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "test" {
+		os.Exit(beater.TestCommand(os.Args[2:]))
+	}
+
+	beat.AddFlagsCallback(keystore.Export)
 	err := beat.Run("ftpbeat", "", beater.New)
 	if err != nil {
 		os.Exit(1)